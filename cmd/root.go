@@ -50,7 +50,11 @@ var rootCmd = &cobra.Command{
 
 			fmt.Fprintf(os.Stderr, "Checking members and teams cache...\n\n")
 
-			dirState, err = directory.NewGitHub(Org, updateCache)
+			opts := []directory.Option{}
+			if debug {
+				opts = append(opts, directory.WithVerboseErrors())
+			}
+			dirState, err = directory.NewGitHub(Org, updateCache, opts...)
 			if err != nil {
 				errorAndExit(fmt.Errorf("unable to get directory client: %+v", err), 1)
 			}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -8,8 +9,12 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var searchJSON bool
+
 func init() {
 	rootCmd.AddCommand(searchCmd)
+
+	searchCmd.Flags().BoolVar(&searchJSON, "json", false, "print the matches as JSON")
 }
 
 var searchCmd = &cobra.Command{
@@ -19,6 +24,15 @@ var searchCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		matches := search(dirState, args)
 
+		if searchJSON {
+			buf, err := json.MarshalIndent(matches, "", "  ")
+			if err != nil {
+				errorAndExit(fmt.Errorf("unable to encode matches: %v", err), 1)
+			}
+			fmt.Println(string(buf))
+			return
+		}
+
 		if len(matches.Members) > 0 {
 			fmt.Println("Members:")
 			for _, u := range matches.Members {
@@ -0,0 +1,808 @@
+package directory
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// cacheHeaderEncrypted marks a cache file as AES-GCM encrypted. Plaintext caches are raw JSON
+	// and never start with this byte.
+	cacheHeaderEncrypted byte = 0x01
+	// cacheHeaderGob marks a cache file as gob encoded
+	cacheHeaderGob byte = 0x02
+	// cacheHeaderJSONLines marks a cache file as newline delimited JSON
+	cacheHeaderJSONLines byte = 0x03
+
+	cacheKeyEnv = "PSST_CACHE_KEY"
+	cacheDirEnv = "PSST_CACHE_DIR"
+
+	// cacheManifestFile maps the hashed per-org cache directories back to org names
+	cacheManifestFile = "manifest.json"
+	defaultBaseURL    = "https://api.github.com/"
+
+	membersCacheFile           = "members"
+	teamsCacheFile             = "teams"
+	activeMembershipsCacheFile = "active-memberships"
+	orgCacheFile               = "org"
+	// directoryCacheFile holds everything with WithSingleFileCache
+	directoryCacheFile = "directory"
+	// cacheChecksumSuffix names the file holding the SHA-256 of a cache file next to it
+	cacheChecksumSuffix = ".sha256"
+	// cacheLockFile is locked with WithCacheLock
+	cacheLockFile = "lock"
+
+	// cacheDirMode keeps other users from reading or planting cache files
+	cacheDirMode os.FileMode = 0700
+)
+
+// errCacheMiss is returned when a cache file exists but can't be trusted and should be re-fetched
+var errCacheMiss = errors.New("cache miss")
+
+// CacheStore keeps the cache, by default as files in the cache directory. WithCacheStore plugs in
+// another backend such as Redis or S3. Keys are slash separated paths starting with the org's cache ID,
+// like "0123456789abcdef/members". Values are serialized by the store, WithCacheFormat, cache encryption
+// and checksums only apply to the default store.
+type CacheStore interface {
+	// Load decodes the value saved under key into v. When nothing is saved under key it returns an
+	// error os.IsNotExist accepts, such as os.ErrNotExist.
+	Load(key string, v interface{}) error
+	// Save stores v under key, replacing any previous value
+	Save(key string, v interface{}) error
+}
+
+// CacheStoreModTime is implemented by stores that know when a key was last saved, so WithCacheTTL
+// applies to them. Entries in other stores are used for as long as the store keeps them.
+type CacheStoreModTime interface {
+	ModTime(key string) (time.Time, error)
+}
+
+// CacheStoreDeleter is implemented by stores that can remove keys, which InvalidateCache needs
+type CacheStoreDeleter interface {
+	Delete(key string) error
+}
+
+// fileCacheStore is the default CacheStore. Its keys are the paths of the cache files.
+type fileCacheStore struct {
+	g *GH
+}
+
+func (s fileCacheStore) Load(key string, v interface{}) error {
+	return s.g.getCachedFile(key, v)
+}
+
+func (s fileCacheStore) Save(key string, v interface{}) error {
+	return s.g.saveCacheFile(key, v)
+}
+
+func (s fileCacheStore) ModTime(key string) (time.Time, error) {
+	fi, err := os.Stat(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// Delete removes the file and its checksum. Files that are already gone are ignored.
+func (s fileCacheStore) Delete(key string) error {
+	for _, f := range []string{key, checksumFile(key)} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// cacheStore returns the store set with WithCacheStore or the files in the cache directory
+func (g *GH) cacheStore() CacheStore {
+	if g.opts.cacheStore != nil {
+		return g.opts.cacheStore
+	}
+	return fileCacheStore{g: g}
+}
+
+// cacheKey is the key the cache file is kept under in the store. Custom stores get the path relative
+// to the cache directory so their keys don't depend on where that would be.
+func (g *GH) cacheKey(filename string) string {
+	if g.opts.cacheStore == nil {
+		return filename
+	}
+	rel, err := filepath.Rel(g.cacheRoot(), filename)
+	if err != nil {
+		return filepath.ToSlash(filename)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// cacheManifestEntry describes which org and API endpoint a hashed cache directory belongs to
+type cacheManifestEntry struct {
+	Org     string `json:"org"`
+	BaseURL string `json:"base_url"`
+	// MemberFilter describes WithMemberFilter, empty when every member is cached
+	MemberFilter string `json:"member_filter,omitempty"`
+}
+
+func (g *GH) baseURL() string {
+	if g.ghClient == nil || g.ghClient.BaseURL == nil {
+		return defaultBaseURL
+	}
+	return g.ghClient.BaseURL.String()
+}
+
+// cacheID is a short hash of the normalized org and API endpoint, and the member filter when there is
+// one. It keeps odd characters in org names out of cache paths and stops different orgs, GitHub
+// instances or filters from sharing a cache.
+func (g *GH) cacheID() string {
+	id := strings.ToLower(strings.TrimSpace(g.Org)) + "\n" + g.baseURL()
+	if filter := g.opts.memberFilter.cacheKey(); filter != "" {
+		id += "\n" + filter
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// cacheRoot is the directory holding every org's cache. WithCacheDir takes precedence over
+// PSST_CACHE_DIR, which takes precedence over the default under the home directory.
+func (g *GH) cacheRoot() string {
+	if g.opts.cacheDir != "" {
+		return g.opts.cacheDir
+	}
+	if dir := os.Getenv(cacheDirEnv); dir != "" {
+		return dir
+	}
+	return cacheDir
+}
+
+func (g *GH) orgCacheDir() string {
+	return filepath.Join(g.cacheRoot(), g.cacheID())
+}
+
+func (g *GH) cacheFile(name string) string {
+	return filepath.Join(g.orgCacheDir(), name)
+}
+
+// createCacheDir creates the org's cache directory, which is safe to do from several processes at once.
+// Directories older versions created readable by everyone are tightened to cacheDirMode.
+func (g *GH) createCacheDir() error {
+	dir := g.orgCacheDir()
+	if err := os.MkdirAll(dir, cacheDirMode); err != nil {
+		return errors.Wrap(err, "unable to create cache directory")
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return errors.Wrap(err, "unable to create cache directory")
+	}
+	if info.Mode().Perm() != cacheDirMode {
+		if err := os.Chmod(dir, cacheDirMode); err != nil {
+			return errors.Wrap(err, "unable to restrict cache directory permissions")
+		}
+	}
+	return nil
+}
+
+// lockCache takes the WithCacheLock lock, waiting for other processes holding it. The returned func
+// releases it.
+func (g *GH) lockCache() (func(), error) {
+	if !g.opts.cacheLock || g.opts.cacheStore != nil {
+		return func() {}, nil
+	}
+	f, err := os.OpenFile(g.cacheFile(cacheLockFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open cache lock")
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "unable to lock cache")
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// withCacheLock runs save while holding the WithCacheLock lock
+func (g *GH) withCacheLock(save func() error) error {
+	unlock, err := g.lockCache()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return save()
+}
+
+// updateCacheManifest records this org's cache directory in the manifest so the cache stays debuggable
+func (g *GH) updateCacheManifest() error {
+	filename := filepath.Join(g.cacheRoot(), cacheManifestFile)
+	manifest := map[string]cacheManifestEntry{}
+
+	buf, err := ioutil.ReadFile(filename)
+	if err == nil {
+		if err := json.Unmarshal(buf, &manifest); err != nil {
+			// A broken manifest only affects debuggability, start a fresh one
+			manifest = map[string]cacheManifestEntry{}
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrap(err, "unable to read cache manifest")
+	}
+
+	entry := cacheManifestEntry{Org: g.Org, BaseURL: g.baseURL(), MemberFilter: g.opts.memberFilter.cacheKey()}
+	if current, ok := manifest[g.cacheID()]; ok && current == entry {
+		return nil
+	}
+	manifest[g.cacheID()] = entry
+
+	buf, err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal cache manifest")
+	}
+	// Orgs don't share a cache lock, so concurrent runs for different orgs can lose each other's
+	// entries, but never leave a partly written manifest
+	if err := writeFileAtomic(filename, buf); err != nil {
+		return errors.Wrap(err, "unable to write cache manifest")
+	}
+	return nil
+}
+
+// setupCacheEncryption prepares the cipher used for the cache from WithCacheEncryption or, when that
+// isn't given, the base64 encoded key in PSST_CACHE_KEY.
+func (g *GH) setupCacheEncryption() error {
+	key := g.opts.cacheKey
+	if key == nil {
+		if encoded := os.Getenv(cacheKeyEnv); encoded != "" {
+			var err error
+			key, err = base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("unable to decode %s, it must be base64 encoded", cacheKeyEnv))
+			}
+		}
+	}
+	if key == nil {
+		return nil
+	}
+
+	aead, err := newCacheCipher(key)
+	if err != nil {
+		return err
+	}
+	g.cacheCipher = aead
+	return nil
+}
+
+func newCacheCipher(key []byte) (cipher.AEAD, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("cache encryption key must be 16, 24 or 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create cache cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create cache cipher")
+	}
+	return aead, nil
+}
+
+// getCachedInfo reads the cache files into info. Caches written before the org file existed are still
+// used, leaving the org metadata empty until the next refresh.
+func (g *GH) getCachedInfo(info *Info, membersFile, teamsFile, activeMembershipsFile, orgFile string) error {
+	if err := g.getCached(membersFile, &info.Members); err != nil {
+		return errors.Wrap(err, "unable to get cached members information")
+	}
+	if err := g.getCached(teamsFile, &info.Teams); err != nil {
+		return errors.Wrap(err, "unable to get cached team information")
+	}
+	info.Teams = g.filterTeams(info.Teams)
+	if err := g.getCached(activeMembershipsFile, &info.ActiveMemberTeams); err != nil {
+		return errors.Wrap(err, "unable to get cached active memberships information")
+	}
+	if err := g.getCached(orgFile, &info.Metadata); err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return errors.Wrap(err, "unable to get cached organization information")
+	}
+	return nil
+}
+
+// filterTeams leaves out the teams WithTeamFilter doesn't keep
+func (g *GH) filterTeams(teams []Team) []Team {
+	if g.opts.teamFilter == "" {
+		return teams
+	}
+	kept := []Team{}
+	for _, t := range teams {
+		if g.keepTeam(t.Name, t.Slug) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// getCachedDirectory is getCachedInfo for the single file written with WithSingleFileCache. A file
+// for another org or from a newer version of psst is treated as a cache miss.
+func (g *GH) getCachedDirectory(info *Info, file string) error {
+	var s snapshot
+	if err := g.getCached(file, &s); err != nil {
+		return errors.Wrap(err, "unable to get cached directory information")
+	}
+	if s.Version > snapshotVersion || !strings.EqualFold(s.Org, info.Org) {
+		g.logf("cache file %s is version %d for organization %s, fetching from GitHub", file, s.Version, s.Org)
+		return errCacheMiss
+	}
+
+	info.Members = s.Members
+	info.Teams = g.filterTeams(s.Teams)
+	info.ActiveMemberTeams = s.ActiveMemberTeams
+	info.Metadata = s.Metadata
+	return nil
+}
+
+// cacheable reports whether freshly fetched members and teams may be cached. The token's own user is
+// always a member, so fetching no members at all points at a missing scope or similar problem rather
+// than an empty org. That result isn't cached, so the next run tries GitHub again instead of trusting it.
+func (g *GH) cacheable(info Info) bool {
+	if g.opts.maxMembers > 0 || g.opts.maxTeams > 0 || g.opts.teamFilter != "" {
+		// A partial directory must never be picked up later by a run without the limits
+		g.logf("member or team limits or a team filter are set, not caching the result")
+		return false
+	}
+	if n := incompleteTeams(info.Teams); n > 0 {
+		// One failed lookup would otherwise last for the whole cache TTL
+		g.logf("%d teams are incomplete, not caching the result", n)
+		return false
+	}
+	if len(info.Members) == 0 && g.opts.memberFilter.cacheKey() == "" {
+		g.logf("no members were returned for organization %s, not caching the result; check that the token has the read:org scope", info.Org)
+		return false
+	}
+	return true
+}
+
+// saveDirectory writes info to the single cache file used with WithSingleFileCache
+func (g *GH) saveDirectory(info Info, file string) error {
+	if !g.cacheable(info) {
+		return nil
+	}
+
+	format := g.opts.cacheFormat
+	if format == CacheFormatJSONLines {
+		format = CacheFormatJSON
+	}
+	if g.opts.cacheStore != nil {
+		if err := g.opts.cacheStore.Save(g.cacheKey(file), g.newSnapshot(info)); err != nil {
+			return errors.Wrap(err, "unable to save directory file")
+		}
+		return nil
+	}
+	buf, err := g.marshalCacheFormat(format, file, g.newSnapshot(info))
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(file, buf); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to write cache file %s", file))
+	}
+	if err := writeFileAtomic(checksumFile(file), []byte(checksum(buf))); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to write checksum of cache file %s", file))
+	}
+	return nil
+}
+
+// writeFileAtomic replaces filename with buf by writing a temporary file next to it and renaming it
+// over the original, so readers see either the old or the new contents and never a partial write
+func writeFileAtomic(filename string, buf []byte) error {
+	return writeFileAtomicFunc(filename, func(w io.Writer) error {
+		_, err := w.Write(buf)
+		return err
+	})
+}
+
+// writeFileAtomicFunc is like writeFileAtomic but lets write produce the contents
+func writeFileAtomicFunc(filename string, write func(io.Writer) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0700); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// saveInfo writes freshly fetched members and teams to the cache unless cacheable says otherwise
+func (g *GH) saveInfo(info Info, membersFile, teamsFile, activeMembershipsFile, orgFile string) error {
+	if !g.cacheable(info) {
+		return nil
+	}
+
+	if err := g.saveCache(membersFile, info.Members); err != nil {
+		return errors.Wrap(err, "unable to save members file")
+	}
+	if err := g.saveCache(teamsFile, g.cacheableTeams(info.Teams)); err != nil {
+		return errors.Wrap(err, "unable to save teams file")
+	}
+	if err := g.saveCache(activeMembershipsFile, g.cacheableTeamNames(info.ActiveMemberTeams, info.Teams)); err != nil {
+		return errors.Wrap(err, "unable to save active memberships file")
+	}
+	if err := g.saveCache(orgFile, info.Metadata); err != nil {
+		return errors.Wrap(err, "unable to save organization file")
+	}
+	return nil
+}
+
+// cacheableTeams leaves out secret teams with WithoutSecretTeams so they are never written to disk
+func (g *GH) cacheableTeams(teams []Team) []Team {
+	if !g.opts.withoutSecretTeams {
+		return teams
+	}
+	cacheable := []Team{}
+	for _, t := range teams {
+		if !t.Secret {
+			cacheable = append(cacheable, t)
+		}
+	}
+	return cacheable
+}
+
+// cacheableTeamNames is like cacheableTeams for a list of team names
+func (g *GH) cacheableTeamNames(names []string, teams []Team) []string {
+	if !g.opts.withoutSecretTeams {
+		return names
+	}
+	secret := make(map[string]struct{})
+	for _, t := range teams {
+		if t.Secret {
+			secret[t.Name] = struct{}{}
+		}
+	}
+	cacheable := []string{}
+	for _, name := range names {
+		if _, ok := secret[name]; !ok {
+			cacheable = append(cacheable, name)
+		}
+	}
+	return cacheable
+}
+
+// saveCache stores v in the cache file, or under its key in the WithCacheStore backend
+func (g *GH) saveCache(filename string, v interface{}) error {
+	return g.cacheStore().Save(g.cacheKey(filename), v)
+}
+
+// getCached is the reverse of saveCache
+func (g *GH) getCached(filename string, v interface{}) error {
+	return g.cacheStore().Load(g.cacheKey(filename), v)
+}
+
+// saveCacheFile writes v to a file in the configured format along with its checksum
+func (g *GH) saveCacheFile(filename string, v interface{}) error {
+	format := g.opts.cacheFormat
+	if format == CacheFormatJSONLines && reflect.ValueOf(v).Kind() != reflect.Slice {
+		// Only lists can be JSON lines, the org's metadata is kept as JSON
+		format = CacheFormatJSON
+	}
+	if format == CacheFormatJSONLines && g.cacheCipher == nil {
+		return g.streamCache(filename, v)
+	}
+
+	buf, err := g.marshalCacheFormat(format, filename, v)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(filename, buf); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to write cache file %s", filename))
+	}
+	// Written last so a file that was only partly saved won't match it
+	if err := writeFileAtomic(checksumFile(filename), []byte(checksum(buf))); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to write checksum of cache file %s", filename))
+	}
+	return nil
+}
+
+// streamCache writes a slice to the cache as JSON lines without building the whole file in memory first
+func (g *GH) streamCache(filename string, v interface{}) error {
+	hash := sha256.New()
+	err := writeFileAtomicFunc(filename, func(f io.Writer) error {
+		w := bufio.NewWriter(io.MultiWriter(f, hash))
+		if err := writeJSONLines(w, v); err != nil {
+			return errors.Wrap(err, "unable to marshal")
+		}
+		return w.Flush()
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to write cache file %s", filename))
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if err := writeFileAtomic(checksumFile(filename), []byte(sum)); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to write checksum of cache file %s", filename))
+	}
+	return nil
+}
+
+// checksumFile is where the checksum of a cache file is kept
+func checksumFile(filename string) string {
+	return filename + cacheChecksumSuffix
+}
+
+func checksum(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// getCachedFile reads a file written by saveCacheFile. A file that doesn't match its checksum is a
+// cache miss.
+func (g *GH) getCachedFile(filename string, v interface{}) error {
+	_, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to read cached file %s", filename))
+	}
+
+	// A missing checksum means the file is from before checksums were added, or its write was cut short
+	sum, err := ioutil.ReadFile(checksumFile(filename))
+	if err != nil || strings.TrimSpace(string(sum)) != checksum(buf) {
+		g.logf("cache file %s doesn't match its checksum, fetching from GitHub", filename)
+		return errCacheMiss
+	}
+	return g.unmarshalCache(filename, buf, v)
+}
+
+// marshalCache serializes v the way it is stored on disk, in the configured format and encrypted when
+// a cache key is set. The name is only used in errors.
+func (g *GH) marshalCache(filename string, v interface{}) ([]byte, error) {
+	return g.marshalCacheFormat(g.opts.cacheFormat, filename, v)
+}
+
+// marshalCacheFormat is like marshalCache but in the given format
+func (g *GH) marshalCacheFormat(format CacheFormat, filename string, v interface{}) ([]byte, error) {
+	buf, err := encodeCache(format, v)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unable to marshal cache file %s", filename))
+	}
+
+	if g.cacheCipher != nil {
+		buf, err = encryptCache(g.cacheCipher, buf)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("unable to encrypt cache file %s", filename))
+		}
+	}
+	return buf, nil
+}
+
+// unmarshalCache is the reverse of marshalCache. Data in any format is accepted, but it must be
+// encrypted exactly when a cache key is set.
+func (g *GH) unmarshalCache(filename string, buf []byte, v interface{}) error {
+	var err error
+	encrypted := len(buf) > 0 && buf[0] == cacheHeaderEncrypted
+	switch {
+	case encrypted && g.cacheCipher == nil:
+		return fmt.Errorf("cache file %s is encrypted but no cache key was provided", filename)
+	case !encrypted && g.cacheCipher != nil:
+		// Plaintext left over from before encryption was enabled, re-fetch so it gets replaced
+		return errCacheMiss
+	case encrypted:
+		buf, err = decryptCache(g.cacheCipher, buf)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to decrypt cache file %s", filename))
+		}
+	}
+
+	if err := decodeCache(buf, v); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to unmarshal cache file: %s", filename))
+	}
+
+	return nil
+}
+
+// encodeCache serializes v in the given format. Gob and JSON lines data are prefixed with a header
+// byte, JSON is left as is so the cache stays readable and older caches keep working.
+func encodeCache(format CacheFormat, v interface{}) ([]byte, error) {
+	switch format {
+	case CacheFormatGob:
+		buf := bytes.NewBuffer([]byte{cacheHeaderGob})
+		if err := gob.NewEncoder(buf).Encode(v); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CacheFormatJSONLines:
+		buf := &bytes.Buffer{}
+		if err := writeJSONLines(buf, v); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return json.Marshal(v)
+	}
+}
+
+// decodeCache detects the format of buf from its first byte, so caches written with a different
+// format than the one currently configured can still be read
+func decodeCache(buf []byte, v interface{}) error {
+	if len(buf) > 0 && buf[0] == cacheHeaderGob {
+		return gob.NewDecoder(bytes.NewReader(buf[1:])).Decode(v)
+	}
+	if len(buf) > 0 && buf[0] == cacheHeaderJSONLines {
+		return readJSONLines(bytes.NewReader(buf[1:]), v, nil)
+	}
+	return json.Unmarshal(buf, v)
+}
+
+// writeJSONLines writes the header byte followed by each element of the slice v on its own line
+func writeJSONLines(w io.Writer, v interface{}) error {
+	items := reflect.ValueOf(v)
+	if items.Kind() != reflect.Slice {
+		return fmt.Errorf("JSON lines cache needs a slice, not %T", v)
+	}
+
+	if _, err := w.Write([]byte{cacheHeaderJSONLines}); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for i := 0; i < items.Len(); i++ {
+		if err := enc.Encode(items.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readJSONLines decodes JSON lines following the header into the slice v points to. With each set the
+// items are handed to it one at a time instead and v is left alone, so only one is held in memory.
+func readJSONLines(r io.Reader, v interface{}, each func(interface{}) error) error {
+	items := reflect.ValueOf(v)
+	if items.Kind() != reflect.Ptr || items.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("JSON lines cache needs a pointer to a slice, not %T", v)
+	}
+	items = items.Elem()
+	if each == nil {
+		items.Set(reflect.MakeSlice(items.Type(), 0, 0))
+	}
+
+	dec := json.NewDecoder(r)
+	for {
+		item := reflect.New(items.Type().Elem())
+		if err := dec.Decode(item.Interface()); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if each != nil {
+			if err := each(item.Elem().Interface()); err != nil {
+				return err
+			}
+			continue
+		}
+		items.Set(reflect.Append(items, item.Elem()))
+	}
+}
+
+// encryptCache seals buf and lays it out as header byte, nonce and ciphertext
+func encryptCache(aead cipher.AEAD, buf []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "unable to generate nonce")
+	}
+
+	out := append([]byte{cacheHeaderEncrypted}, nonce...)
+	return aead.Seal(out, nonce, buf, nil), nil
+}
+
+func decryptCache(aead cipher.AEAD, buf []byte) ([]byte, error) {
+	buf = buf[1:]
+	if len(buf) < aead.NonceSize() {
+		return nil, errors.New("encrypted cache is truncated")
+	}
+
+	nonce, ciphertext := buf[:aead.NonceSize()], buf[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "wrong cache key or corrupted cache")
+	}
+	return plain, nil
+}
+
+// cacheTTL returns how long cache files are used before being fetched again
+func (g *GH) cacheTTL() time.Duration {
+	if g.opts.cacheTTLSet {
+		return g.opts.cacheTTL
+	}
+	return cacheTTL * time.Minute
+}
+
+// cacheExpired reports whether a cache file is missing or older than the TTL. A TTL of zero expires
+// every file right away. Entries in stores that can't tell when they were saved never expire here.
+func (g *GH) cacheExpired(file string) bool {
+	ttl := g.cacheTTL()
+	if ttl <= 0 {
+		return true
+	}
+	store, ok := g.cacheStore().(CacheStoreModTime)
+	if !ok {
+		return false
+	}
+	modTime, err := store.ModTime(g.cacheKey(file))
+	return err != nil || time.Since(modTime) > ttl
+}
+
+// cacheFileNames are the cache files this client reads and writes
+func (g *GH) cacheFileNames() []string {
+	if g.opts.singleFileCache {
+		return []string{directoryCacheFile}
+	}
+	return []string{membersCacheFile, teamsCacheFile, activeMembershipsCacheFile, orgCacheFile}
+}
+
+// CacheFiles returns the absolute paths of the org's cache files and their checksums, whether or not
+// they have been written yet, for diagnostics and backups. It returns nothing when the cache is kept in
+// a store set with WithCacheStore.
+func (g *GH) CacheFiles() []string {
+	files := []string{}
+	if g.opts.cacheStore != nil {
+		return files
+	}
+	for _, name := range g.cacheFileNames() {
+		filename := g.cacheFile(name)
+		if abs, err := filepath.Abs(filename); err == nil {
+			filename = abs
+		}
+		files = append(files, filename, checksumFile(filename))
+	}
+	return files
+}
+
+// InvalidateCache deletes the org's cache files so the next client or Refresh fetches from GitHub. The
+// members and teams already loaded stay usable. Files that are already gone are ignored. A store set
+// with WithCacheStore has to implement CacheStoreDeleter.
+func (g *GH) InvalidateCache() error {
+	g.refreshMu.Lock()
+	defer g.refreshMu.Unlock()
+
+	store, ok := g.cacheStore().(CacheStoreDeleter)
+	if !ok {
+		return errors.New("cache store doesn't support deleting entries")
+	}
+	for _, name := range []string{membersCacheFile, teamsCacheFile, activeMembershipsCacheFile, orgCacheFile, directoryCacheFile} {
+		if err := store.Delete(g.cacheKey(g.cacheFile(name))); err != nil {
+			return errors.Wrap(err, "unable to remove cache file")
+		}
+	}
+	return nil
+}
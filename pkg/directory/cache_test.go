@@ -0,0 +1,934 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+func TestCacheEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	aead, err := newCacheCipher(key)
+	if err != nil {
+		t.Fatalf("unable to create cipher: %v", err)
+	}
+	otherAead, err := newCacheCipher([]byte("fedcba9876543210"))
+	if err != nil {
+		t.Fatalf("unable to create cipher: %v", err)
+	}
+
+	members := []Member{Member{Login: "test1", Name: "Test 1"}, Member{Login: "test2", Name: ""}}
+	encryptedFile := filepath.Join(dir, "encrypted")
+	if err := (&GH{cacheCipher: aead}).saveCache(encryptedFile, members); err != nil {
+		t.Fatalf("unable to save encrypted cache: %v", err)
+	}
+	plainFile := filepath.Join(dir, "plain")
+	if err := (&GH{}).saveCache(plainFile, members); err != nil {
+		t.Fatalf("unable to save plaintext cache: %v", err)
+	}
+
+	cases := map[string]struct {
+		State     *GH
+		File      string
+		Err       bool
+		CacheMiss bool
+	}{
+		"TestEncryptedRoundTrip": {
+			State: &GH{cacheCipher: aead},
+			File:  encryptedFile,
+		},
+		"TestPlaintextRoundTrip": {
+			State: &GH{},
+			File:  plainFile,
+		},
+		"TestWrongKey": {
+			State: &GH{cacheCipher: otherAead},
+			File:  encryptedFile,
+			Err:   true,
+		},
+		"TestMissingKey": {
+			State: &GH{},
+			File:  encryptedFile,
+			Err:   true,
+		},
+		"TestPlaintextWithKey": {
+			State:     &GH{cacheCipher: aead},
+			File:      plainFile,
+			Err:       true,
+			CacheMiss: true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := []Member{}
+			err := c.State.getCached(c.File, &got)
+			if c.Err {
+				if err == nil {
+					t.Fatalf("Name: %s, expected an error", name)
+				}
+				if c.CacheMiss != (errors.Cause(err) == errCacheMiss) {
+					t.Errorf("Name: %s, got: %v, expected cache miss: %v", name, err, c.CacheMiss)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Name: %s, unexpected error: %v", name, err)
+			}
+			if !checkMembers(got, members) {
+				t.Errorf("Name: %s, got: %+v, expected: %+v", name, got, members)
+			}
+		})
+	}
+}
+
+func TestCacheFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	aead, err := newCacheCipher([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("unable to create cipher: %v", err)
+	}
+	teams := []Team{Team{Name: "team1", Members: []string{"test1", "test2"}}, Team{Name: "team2", Members: []string{}}}
+
+	cases := map[string]struct {
+		Writer *GH
+		Reader *GH
+	}{
+		"TestGob": {
+			Writer: &GH{opts: options{cacheFormat: CacheFormatGob}},
+			Reader: &GH{},
+		},
+		"TestJSONReadByGob": {
+			Writer: &GH{},
+			Reader: &GH{opts: options{cacheFormat: CacheFormatGob}},
+		},
+		"TestEncryptedGob": {
+			Writer: &GH{opts: options{cacheFormat: CacheFormatGob}, cacheCipher: aead},
+			Reader: &GH{cacheCipher: aead},
+		},
+		"TestJSONLines": {
+			Writer: &GH{opts: options{cacheFormat: CacheFormatJSONLines}},
+			Reader: &GH{},
+		},
+		"TestEncryptedJSONLines": {
+			Writer: &GH{opts: options{cacheFormat: CacheFormatJSONLines}, cacheCipher: aead},
+			Reader: &GH{cacheCipher: aead},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			filename := filepath.Join(dir, name)
+			if err := c.Writer.saveCache(filename, teams); err != nil {
+				t.Fatalf("Name: %s, unable to save cache: %v", name, err)
+			}
+			got := []Team{}
+			if err := c.Reader.getCached(filename, &got); err != nil {
+				t.Fatalf("Name: %s, unable to read cache: %v", name, err)
+			}
+			if !checkTeams(got, teams) {
+				t.Errorf("Name: %s, got: %+v, expected: %+v", name, got, teams)
+			}
+		})
+	}
+}
+
+func TestNewCacheCipherKeyLength(t *testing.T) {
+	if _, err := newCacheCipher([]byte("short")); err == nil {
+		t.Errorf("expected an error for a 5 byte key")
+	}
+}
+
+func TestCacheID(t *testing.T) {
+	a := &GH{Info: Info{Org: "Test-Org"}}
+	b := &GH{Info: Info{Org: " test-org "}}
+	c := &GH{Info: Info{Org: "other/org"}}
+
+	if a.cacheID() != b.cacheID() {
+		t.Errorf("expected normalized orgs to share a cache id, got: %s and %s", a.cacheID(), b.cacheID())
+	}
+	if a.cacheID() == c.cacheID() {
+		t.Errorf("expected different orgs to have different cache ids, got: %s", a.cacheID())
+	}
+	if filepath.Base(c.orgCacheDir()) != c.cacheID() {
+		t.Errorf("expected org name to stay out of the cache path, got: %s", c.orgCacheDir())
+	}
+}
+
+func TestWarmCacheRefreshResult(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(orig string) { cacheDir = orig }(cacheDir)
+	cacheDir = dir
+
+	g := &GH{Info: Info{Org: "test"}}
+	if err := os.MkdirAll(g.orgCacheDir(), 0700); err != nil {
+		t.Fatalf("unable to create cache dir: %v", err)
+	}
+	members := []Member{Member{Login: "test1"}, Member{Login: "test2"}}
+	teams := []Team{Team{Name: "team1", Members: []string{"test1"}}}
+	for file, v := range map[string]interface{}{membersCacheFile: members, teamsCacheFile: teams, activeMembershipsCacheFile: []string{"team1"}} {
+		if err := g.saveCache(g.cacheFile(file), v); err != nil {
+			t.Fatalf("unable to save %s: %v", file, err)
+		}
+	}
+
+	result, err := g.getMembersAndTeams(context.Background(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.FromCache || result.MembersFetched != 2 || result.TeamsFetched != 1 {
+		t.Errorf("got: %+v, expected 2 members and 1 team from the cache", result)
+	}
+	if !g.FromCache() {
+		t.Errorf("expected FromCache to report the cache was used")
+	}
+	if g.LastRefresh() != result {
+		t.Errorf("got: %+v, expected LastRefresh to be %+v", g.LastRefresh(), result)
+	}
+	if !checkMembers(g.GetMembers(), members) {
+		t.Errorf("got: %+v, expected: %+v", g.GetMembers(), members)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fresh := filepath.Join(dir, "fresh")
+	if err := ioutil.WriteFile(fresh, []byte("[]"), 0700); err != nil {
+		t.Fatalf("unable to write cache file: %v", err)
+	}
+	stale := filepath.Join(dir, "stale")
+	if err := ioutil.WriteFile(stale, []byte("[]"), 0700); err != nil {
+		t.Fatalf("unable to write cache file: %v", err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatalf("unable to age cache file: %v", err)
+	}
+
+	cases := map[string]struct {
+		Options  []Option
+		File     string
+		Expected bool
+	}{
+		"TestDefaultFresh":   {File: fresh, Expected: false},
+		"TestDefaultStale":   {File: stale, Expected: true},
+		"TestMissing":        {File: filepath.Join(dir, "missing"), Expected: true},
+		"TestLongTTL":        {Options: []Option{WithCacheTTL(3 * time.Hour)}, File: stale, Expected: false},
+		"TestZeroTTL":        {Options: []Option{WithCacheTTL(0)}, File: fresh, Expected: true},
+		"TestNegativeIgnore": {Options: []Option{WithCacheTTL(-time.Minute)}, File: fresh, Expected: false},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			g := &GH{}
+			for _, opt := range c.Options {
+				opt(&g.opts)
+			}
+			if got := g.cacheExpired(c.File); got != c.Expected {
+				t.Errorf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+			}
+		})
+	}
+}
+
+func TestCacheChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	g := &GH{}
+	members := []Member{Member{Login: "test1", Name: "Test 1"}}
+
+	cases := map[string]struct {
+		Change   func(file string) error
+		Expected error
+	}{
+		"TestUnchanged": {
+			Change: func(string) error { return nil },
+		},
+		"TestTampered": {
+			Change: func(file string) error {
+				return ioutil.WriteFile(file, []byte(`[{"login":"someoneelse"}]`), 0700)
+			},
+			Expected: errCacheMiss,
+		},
+		"TestMissingChecksum": {
+			Change:   func(file string) error { return os.Remove(checksumFile(file)) },
+			Expected: errCacheMiss,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(dir, name)
+			if err := g.saveCache(file, members); err != nil {
+				t.Fatalf("Name: %s, unable to save cache: %v", name, err)
+			}
+			if err := c.Change(file); err != nil {
+				t.Fatalf("Name: %s, unable to change cache: %v", name, err)
+			}
+
+			var got []Member
+			err := g.getCached(file, &got)
+			if errors.Cause(err) != c.Expected {
+				t.Fatalf("Name: %s, got: %v, expected: %v", name, err, c.Expected)
+			}
+			if err == nil && !checkMembers(got, members) {
+				t.Errorf("Name: %s, got: %+v, expected: %+v", name, got, members)
+			}
+		})
+	}
+}
+
+func TestCacheRoot(t *testing.T) {
+	defer restoreEnv(cacheDirEnv)()
+
+	cases := map[string]struct {
+		Env      string
+		Options  []Option
+		Expected string
+	}{
+		"TestDefault": {
+			Expected: cacheDir,
+		},
+		"TestEnv": {
+			Env:      "/tmp/psst-env",
+			Expected: "/tmp/psst-env",
+		},
+		"TestOption": {
+			Options:  []Option{WithCacheDir("/tmp/psst-option")},
+			Expected: "/tmp/psst-option",
+		},
+		"TestOptionOverEnv": {
+			Env:      "/tmp/psst-env",
+			Options:  []Option{WithCacheDir("/tmp/psst-option")},
+			Expected: "/tmp/psst-option",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if c.Env == "" {
+				os.Unsetenv(cacheDirEnv)
+			} else {
+				os.Setenv(cacheDirEnv, c.Env)
+			}
+			g := &GH{}
+			for _, opt := range c.Options {
+				opt(&g.opts)
+			}
+			if got := g.cacheRoot(); got != c.Expected {
+				t.Errorf("Name: %s, got: %s, expected: %s", name, got, c.Expected)
+			}
+		})
+	}
+}
+
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestSaveEmptyInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := map[string]struct {
+		Options []Option
+		Info    Info
+		Cached  bool
+	}{
+		"TestMembers":   {Info: Info{Org: "test", Members: []Member{Member{Login: "test1"}}}, Cached: true},
+		"TestNoTeams":   {Info: Info{Org: "test", Members: []Member{Member{Login: "test1"}}, Teams: []Team{}}, Cached: true},
+		"TestNoMembers": {Info: Info{Org: "test", Teams: []Team{Team{Name: "team1"}}}, Cached: false},
+		"TestLimited":   {Options: []Option{WithMaxMembers(1)}, Info: Info{Org: "test", Members: []Member{Member{Login: "test1"}}}, Cached: false},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			logger := &testLogger{}
+			g := &GH{}
+			WithLogger(logger)(&g.opts)
+			for _, opt := range c.Options {
+				opt(&g.opts)
+			}
+
+			base := filepath.Join(dir, name)
+			files := []string{base + "-members", base + "-teams", base + "-active", base + "-org"}
+			if err := g.saveInfo(c.Info, files[0], files[1], files[2], files[3]); err != nil {
+				t.Fatalf("Name: %s, unexpected error: %v", name, err)
+			}
+			for _, file := range files {
+				if _, err := os.Stat(file); (err == nil) != c.Cached {
+					t.Errorf("Name: %s, got stat of %s: %v, expected cached: %v", name, file, err, c.Cached)
+				}
+			}
+			if (len(logger.lines) == 0) != c.Cached {
+				t.Errorf("Name: %s, got logs: %v, expected a warning only when nothing is cached", name, logger.lines)
+			}
+		})
+	}
+}
+
+func TestWithoutSecretTeams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	info := Info{
+		Org:               "test",
+		Members:           []Member{Member{Login: "test1"}},
+		Teams:             []Team{Team{Name: "team1", Members: []string{"test1"}}, Team{Name: "hidden", Secret: true, Members: []string{"test1"}}},
+		ActiveMemberTeams: []string{"team1", "hidden"},
+	}
+
+	cases := map[string]struct {
+		Options     []Option
+		Teams       []string
+		Memberships []string
+	}{
+		"TestDefault":       {Teams: []string{"team1", "hidden"}, Memberships: []string{"team1", "hidden"}},
+		"TestWithoutSecret": {Options: []Option{WithoutSecretTeams()}, Teams: []string{"team1"}, Memberships: []string{"team1"}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			g := &GH{}
+			for _, opt := range c.Options {
+				opt(&g.opts)
+			}
+			g.setInfo(info)
+
+			base := filepath.Join(dir, name)
+			if err := g.saveInfo(info, base+"-members", base+"-teams", base+"-active", base+"-org"); err != nil {
+				t.Fatalf("Name: %s, unexpected error: %v", name, err)
+			}
+
+			var teams []Team
+			if err := g.getCached(base+"-teams", &teams); err != nil {
+				t.Fatalf("Name: %s, unable to read teams: %v", name, err)
+			}
+			var memberships []string
+			if err := g.getCached(base+"-active", &memberships); err != nil {
+				t.Fatalf("Name: %s, unable to read memberships: %v", name, err)
+			}
+			if len(teams) != len(c.Teams) || len(memberships) != len(c.Memberships) {
+				t.Errorf("Name: %s, got: %+v %v, expected: %v %v", name, teams, memberships, c.Teams, c.Memberships)
+			}
+			for i := range teams {
+				if teams[i].Name != c.Teams[i] {
+					t.Errorf("Name: %s, got: %+v, expected: %v", name, teams, c.Teams)
+				}
+			}
+			if _, ok := g.IsTeam("hidden"); !ok {
+				t.Errorf("Name: %s, expected the secret team to stay in memory", name)
+			}
+		})
+	}
+}
+
+func TestInvalidateCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	g := &GH{}
+	g.Org = "test"
+	WithCacheDir(dir)(&g.opts)
+	WithCacheTTL(time.Hour)(&g.opts)
+	if err := os.MkdirAll(g.orgCacheDir(), os.ModePerm); err != nil {
+		t.Fatalf("unable to create cache dir: %v", err)
+	}
+	info := Info{Org: "test", Members: []Member{Member{Login: "test1"}}, Teams: []Team{}, ActiveMemberTeams: []string{}}
+	files := []string{g.cacheFile(membersCacheFile), g.cacheFile(teamsCacheFile), g.cacheFile(activeMembershipsCacheFile), g.cacheFile(orgCacheFile)}
+	if err := g.saveInfo(info, files[0], files[1], files[2], files[3]); err != nil {
+		t.Fatalf("unable to save cache: %v", err)
+	}
+	g.setInfo(info)
+
+	if err := g.InvalidateCache(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, file := range files {
+		if !g.cacheExpired(file) {
+			t.Errorf("got: %s still fresh, expected it to be expired", file)
+		}
+		if _, err := os.Stat(checksumFile(file)); !os.IsNotExist(err) {
+			t.Errorf("got: %v, expected the checksum of %s to be removed", err, file)
+		}
+	}
+	if len(g.GetMembers()) != 1 {
+		t.Errorf("got: %v, expected the loaded members to stay", g.GetMembers())
+	}
+
+	// Nothing left to remove isn't an error
+	if err := g.InvalidateCache(); err != nil {
+		t.Errorf("unexpected error invalidating again: %v", err)
+	}
+}
+
+func TestJSONLinesCache(t *testing.T) {
+	server := testGitHubServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	newState := func() *GH {
+		g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+		g.Org = "test"
+		g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+		g.UsersService = g.ghClient.Users
+		WithCacheDir(dir)(&g.opts)
+		WithCacheTTL(time.Hour)(&g.opts)
+		WithCacheFormat(CacheFormatJSONLines)(&g.opts)
+		return g
+	}
+
+	// The org file isn't a list, so it has to be saved as JSON along with the JSON lines files
+	if _, err := newState().getMembersAndTeams(context.Background(), true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cached := newState()
+	result, err := cached.getMembersAndTeams(context.Background(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.FromCache {
+		t.Errorf("got: %+v, expected the JSON lines cache to be used", result)
+	}
+	checkLogins(t, "TestJSONLinesCacheMembers", cached.GetMembers(), []string{"test1", "test2"})
+	if got := cached.DefaultRepoPermission(); got != "read" {
+		t.Errorf("got: %q, expected the cached default repository permission", got)
+	}
+}
+
+func TestSingleFileCache(t *testing.T) {
+	server := testGitHubServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	newState := func(opts ...Option) *GH {
+		g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+		g.Org = "test"
+		g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+		g.UsersService = g.ghClient.Users
+		WithCacheDir(dir)(&g.opts)
+		WithCacheTTL(time.Hour)(&g.opts)
+		WithSingleFileCache()(&g.opts)
+		for _, opt := range opts {
+			opt(&g.opts)
+		}
+		return g
+	}
+
+	g := newState(WithCacheFormat(CacheFormatJSONLines))
+	if _, err := g.getMembersAndTeams(context.Background(), true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, file := range []string{membersCacheFile, teamsCacheFile, activeMembershipsCacheFile} {
+		if _, err := os.Stat(g.cacheFile(file)); !os.IsNotExist(err) {
+			t.Errorf("got: %v, expected %s not to be written", err, file)
+		}
+	}
+
+	cached := newState()
+	result, err := cached.getMembersAndTeams(context.Background(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.FromCache {
+		t.Errorf("got: %+v, expected the single cache file to be used", result)
+	}
+	checkLogins(t, "TestSingleFileCacheMembers", cached.GetMembers(), []string{"test1", "test2"})
+	if teams := cached.GetTeams(); len(teams) != 1 || teams[0].Name != "team1" || len(teams[0].Members) != 2 {
+		t.Errorf("got: %+v, expected team1 with both members", teams)
+	}
+	if got := cached.GetActiveMemberTeams(); len(got) != 1 || got[0] != "team1" {
+		t.Errorf("got: %v, expected the active member's teams", got)
+	}
+
+	var streamed []string
+	if err := cached.ForEachCachedMember(func(m Member) error {
+		streamed = append(streamed, m.Login)
+		return nil
+	}); err != nil || len(streamed) != 2 {
+		t.Errorf("got: %v %v, expected both members from the single cache file", streamed, err)
+	}
+
+	// A file written by a newer version is fetched again rather than misread
+	newer := newState()
+	s := newer.newSnapshot(Info{Org: "test", Members: []Member{Member{Login: "future"}}})
+	s.Version = snapshotVersion + 1
+	buf, err := newer.marshalCache(snapshotName, s)
+	if err != nil {
+		t.Fatalf("unable to marshal snapshot: %v", err)
+	}
+	if err := writeFileAtomic(newer.cacheFile(directoryCacheFile), buf); err != nil {
+		t.Fatalf("unable to write cache file: %v", err)
+	}
+	if err := ioutil.WriteFile(checksumFile(newer.cacheFile(directoryCacheFile)), []byte(checksum(buf)), 0700); err != nil {
+		t.Fatalf("unable to write checksum: %v", err)
+	}
+	result, err = newer.getMembersAndTeams(context.Background(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.FromCache {
+		t.Errorf("got: %+v, expected a newer cache file to be fetched again", result)
+	}
+	checkLogins(t, "TestSingleFileCacheNewer", newer.GetMembers(), []string{"test1", "test2"})
+
+	if err := newer.InvalidateCache(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(newer.cacheFile(directoryCacheFile)); !os.IsNotExist(err) {
+		t.Errorf("got: %v, expected the single cache file to be removed", err)
+	}
+}
+
+// memoryCacheStore keeps the cache as JSON in a map
+type memoryCacheStore struct {
+	values   map[string][]byte
+	modTimes map[string]time.Time
+}
+
+func (s *memoryCacheStore) Load(key string, v interface{}) error {
+	buf, ok := s.values[key]
+	if !ok {
+		return os.ErrNotExist
+	}
+	return json.Unmarshal(buf, v)
+}
+
+func (s *memoryCacheStore) Save(key string, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.values[key] = buf
+	return nil
+}
+
+func (s *memoryCacheStore) Delete(key string) error {
+	delete(s.values, key)
+	return nil
+}
+
+// agingCacheStore also reports when keys were saved
+type agingCacheStore struct {
+	*memoryCacheStore
+}
+
+func (s agingCacheStore) ModTime(key string) (time.Time, error) {
+	t, ok := s.modTimes[key]
+	if !ok {
+		return time.Time{}, os.ErrNotExist
+	}
+	return t, nil
+}
+
+func TestCacheStore(t *testing.T) {
+	server := testGitHubServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &memoryCacheStore{values: map[string][]byte{}, modTimes: map[string]time.Time{}}
+	newState := func(store CacheStore, opts ...Option) *GH {
+		g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+		g.Org = "test"
+		g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+		g.UsersService = g.ghClient.Users
+		WithCacheDir(dir)(&g.opts)
+		WithCacheStore(store)(&g.opts)
+		for _, opt := range opts {
+			opt(&g.opts)
+		}
+		return g
+	}
+
+	g := newState(store)
+	result, err := g.getMembersAndTeams(context.Background(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.FromCache {
+		t.Errorf("got: %+v, expected an empty store to be fetched", result)
+	}
+	if _, ok := store.values[g.cacheID()+"/"+membersCacheFile]; !ok {
+		t.Errorf("got keys: %v, expected the members to be saved under the org's cache ID", store.values)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("got: %d files, expected nothing to be written to the cache directory", len(files))
+	}
+
+	cached := newState(store)
+	result, err = cached.getMembersAndTeams(context.Background(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.FromCache {
+		t.Errorf("got: %+v, expected the store to be used", result)
+	}
+	checkLogins(t, "TestCacheStoreMembers", cached.GetMembers(), []string{"test1", "test2"})
+	var streamed int
+	if err := cached.ForEachCachedMember(func(Member) error { streamed++; return nil }); err != nil || streamed != 2 {
+		t.Errorf("got: %d members %v, expected both members from the store", streamed, err)
+	}
+
+	// Keys older than the TTL are fetched again when the store reports their age
+	for key := range store.values {
+		store.modTimes[key] = time.Now().Add(-2 * time.Hour)
+	}
+	aging := newState(agingCacheStore{store}, WithCacheTTL(time.Hour))
+	if result, err := aging.getMembersAndTeams(context.Background(), false); err != nil || result.FromCache {
+		t.Errorf("got: %+v %v, expected the old keys to be fetched again", result, err)
+	}
+
+	if err := cached.InvalidateCache(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(store.values) != 0 {
+		t.Errorf("got keys: %v, expected them to be deleted", store.values)
+	}
+}
+
+func TestCacheFiles(t *testing.T) {
+	server := testGitHubServer()
+	defer server.Close()
+
+	cases := map[string]struct {
+		opts  []Option
+		files []string
+	}{
+		"TestSeparateFiles": {files: []string{membersCacheFile, teamsCacheFile, activeMembershipsCacheFile, orgCacheFile}},
+		"TestSingleFile":    {opts: []Option{WithSingleFileCache()}, files: []string{directoryCacheFile}},
+	}
+
+	for name, c := range cases {
+		dir, err := ioutil.TempDir("", "psst-cache")
+		if err != nil {
+			t.Fatalf("unable to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+
+		g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+		g.Org = "test"
+		g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+		g.UsersService = g.ghClient.Users
+		WithCacheDir(dir)(&g.opts)
+		for _, o := range c.opts {
+			o(&g.opts)
+		}
+		if _, err := g.getMembersAndTeams(context.Background(), true); err != nil {
+			t.Fatalf("Name: %s, unexpected error: %v", name, err)
+		}
+
+		expected := []string{}
+		for _, f := range c.files {
+			expected = append(expected, g.cacheFile(f), checksumFile(g.cacheFile(f)))
+		}
+		files := g.CacheFiles()
+		if strings.Join(files, ",") != strings.Join(expected, ",") {
+			t.Errorf("Name: %s, got: %v, expected: %v", name, files, expected)
+		}
+		for _, f := range files {
+			if !filepath.IsAbs(f) {
+				t.Errorf("Name: %s, got: %s, expected an absolute path", name, f)
+			}
+			if _, err := os.Stat(f); err != nil {
+				t.Errorf("Name: %s, got: %v, expected %s to have been written", name, err, f)
+			}
+		}
+	}
+
+	relative := &GH{opts: defaultOptions()}
+	relative.Org = "test"
+	WithCacheDir("cache")(&relative.opts)
+	for _, f := range relative.CacheFiles() {
+		if !filepath.IsAbs(f) {
+			t.Errorf("got: %s, expected a relative cache dir to give absolute paths", f)
+		}
+	}
+
+	stored := &GH{opts: defaultOptions()}
+	stored.Org = "test"
+	WithCacheStore(&memoryCacheStore{values: map[string][]byte{}, modTimes: map[string]time.Time{}})(&stored.opts)
+	if files := stored.CacheFiles(); len(files) != 0 {
+		t.Errorf("got: %v, expected no files with a custom store", files)
+	}
+}
+
+func TestCreateCacheDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := map[string]struct {
+		existing os.FileMode
+	}{
+		"TestNewDir":       {},
+		"TestExistingDir":  {existing: 0700},
+		"TestWorldOpenDir": {existing: 0777},
+	}
+
+	for name, c := range cases {
+		g := &GH{opts: defaultOptions()}
+		g.Org = name
+		WithCacheDir(dir)(&g.opts)
+		if c.existing != 0 {
+			if err := os.MkdirAll(g.orgCacheDir(), c.existing); err != nil {
+				t.Fatalf("Name: %s, unable to create cache dir: %v", name, err)
+			}
+			// The umask may have kept MkdirAll from setting every bit
+			if err := os.Chmod(g.orgCacheDir(), c.existing); err != nil {
+				t.Fatalf("Name: %s, unable to set cache dir mode: %v", name, err)
+			}
+		}
+
+		if err := g.createCacheDir(); err != nil {
+			t.Errorf("Name: %s, unexpected error: %v", name, err)
+			continue
+		}
+		info, err := os.Stat(g.orgCacheDir())
+		if err != nil {
+			t.Errorf("Name: %s, unexpected error: %v", name, err)
+			continue
+		}
+		if info.Mode().Perm() != 0700 {
+			t.Errorf("Name: %s, got: %v, expected: %v", name, info.Mode().Perm(), os.FileMode(0700))
+		}
+	}
+}
+
+func TestConcurrentCacheWrites(t *testing.T) {
+	server := testGitHubServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	newState := func(opts ...Option) *GH {
+		g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+		g.Org = "test"
+		g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+		g.UsersService = g.ghClient.Users
+		WithCacheDir(dir)(&g.opts)
+		for _, o := range append(opts, WithCacheLock()) {
+			o(&g.opts)
+		}
+		return g
+	}
+
+	// Separate clients stand in for separate processes, each with its own lock file descriptor
+	errs := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func(g *GH) {
+			_, err := g.getMembersAndTeams(context.Background(), true)
+			errs <- err
+		}(newState(WithCacheFormat(CacheFormatJSONLines)))
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	cached := newState(WithCacheFormat(CacheFormatJSONLines))
+	result, err := cached.getMembersAndTeams(context.Background(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.FromCache {
+		t.Errorf("got: %+v, expected the cache written concurrently to be intact", result)
+	}
+	checkLogins(t, "TestConcurrentCacheWrites", cached.GetMembers(), []string{"test1", "test2"})
+
+	// A second holder waits until the first lets go
+	unlock, err := cached.lockCache()
+	if err != nil {
+		t.Fatalf("unable to lock cache: %v", err)
+	}
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := newState().lockCache()
+		if err != nil {
+			t.Errorf("unable to lock cache: %v", err)
+		} else {
+			unlock()
+		}
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Errorf("expected the lock to wait for the first holder")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Errorf("expected the lock to be taken once released")
+	}
+}
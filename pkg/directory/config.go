@@ -0,0 +1,78 @@
+package directory
+
+import (
+	"context"
+	"time"
+)
+
+// Config is a declarative alternative to passing options, for apps that load their settings from a
+// file. Zero values leave the default in place. Durations are time.Duration values, so they are
+// nanoseconds when decoded from JSON.
+type Config struct {
+	Org string `json:"org"`
+	// Token is the GitHub token. TokenFile is read instead when it is empty, and GITHUB_TOKEN when
+	// both are.
+	Token     string `json:"token,omitempty"`
+	TokenFile string `json:"tokenFile,omitempty"`
+	// BaseURL is the API endpoint, taken from GITHUB_API_URL or github.com when empty
+	BaseURL  string `json:"baseUrl,omitempty"`
+	CacheDir string `json:"cacheDir,omitempty"`
+	// CacheTTL is how long the cache is used before refetching. It is a pointer because a zero TTL,
+	// which always fetches, differs from leaving the default.
+	CacheTTL       *time.Duration `json:"cacheTtl,omitempty"`
+	Workers        int            `json:"workers,omitempty"`
+	PerPage        int            `json:"perPage,omitempty"`
+	Timeout        time.Duration  `json:"timeout,omitempty"`
+	ForceRefresh   bool           `json:"forceRefresh,omitempty"`
+	VerboseErrors  bool           `json:"verboseErrors,omitempty"`
+	PendingMembers bool           `json:"pendingMembers,omitempty"`
+	TeamRepos      bool           `json:"teamRepos,omitempty"`
+}
+
+// Options returns the options equivalent to the config
+func (c Config) Options() []Option {
+	opts := []Option{}
+	if c.Token != "" {
+		opts = append(opts, WithToken(c.Token))
+	}
+	if c.TokenFile != "" {
+		opts = append(opts, WithTokenFile(c.TokenFile))
+	}
+	if c.BaseURL != "" {
+		opts = append(opts, WithBaseURL(c.BaseURL))
+	}
+	if c.CacheDir != "" {
+		opts = append(opts, WithCacheDir(c.CacheDir))
+	}
+	if c.CacheTTL != nil {
+		opts = append(opts, WithCacheTTL(*c.CacheTTL))
+	}
+	if c.Workers > 0 {
+		opts = append(opts, WithWorkers(c.Workers))
+	}
+	if c.PerPage > 0 {
+		opts = append(opts, WithPerPage(c.PerPage))
+	}
+	if c.Timeout > 0 {
+		opts = append(opts, WithTimeout(c.Timeout))
+	}
+	if c.ForceRefresh {
+		opts = append(opts, WithForceRefresh())
+	}
+	if c.VerboseErrors {
+		opts = append(opts, WithVerboseErrors())
+	}
+	if c.PendingMembers {
+		opts = append(opts, WithPendingMembers())
+	}
+	if c.TeamRepos {
+		opts = append(opts, WithTeamRepos())
+	}
+	return opts
+}
+
+// NewGitHubConfig is like NewGitHub but takes its settings from cfg. Options without a Config field,
+// such as WithLogger, can be added with NewGitHubContext(ctx, cfg.Org, append(cfg.Options(), ...)...).
+func NewGitHubConfig(cfg Config) (*GH, error) {
+	return NewGitHubContext(context.Background(), cfg.Org, cfg.Options()...)
+}
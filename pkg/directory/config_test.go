@@ -0,0 +1,70 @@
+package directory
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConfigOptions(t *testing.T) {
+	ttl := time.Duration(0)
+	cases := map[string]struct {
+		Config   Config
+		Expected options
+	}{
+		"TestZero": {
+			Expected: defaultOptions(),
+		},
+		"TestSet": {
+			Config: Config{Token: "token", BaseURL: "https://github.example.com/api/v3", CacheDir: "/tmp/psst", CacheTTL: &ttl, Workers: 4, Timeout: time.Minute, PendingMembers: true},
+			Expected: func() options {
+				o := defaultOptions()
+				o.token = "token"
+				o.baseURL = "https://github.example.com/api/v3"
+				o.cacheDir = "/tmp/psst"
+				o.cacheTTLSet = true
+				o.workers = 4
+				o.timeout = time.Minute
+				o.pendingMembers = true
+				return o
+			}(),
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := defaultOptions()
+			for _, opt := range c.Config.Options() {
+				opt(&got)
+			}
+			if got.token != c.Expected.token || got.baseURL != c.Expected.baseURL || got.cacheDir != c.Expected.cacheDir ||
+				got.cacheTTL != c.Expected.cacheTTL || got.cacheTTLSet != c.Expected.cacheTTLSet || got.workers != c.Expected.workers ||
+				got.timeout != c.Expected.timeout || got.pendingMembers != c.Expected.pendingMembers {
+				t.Errorf("Name: %s, got: %+v, expected: %+v", name, got, c.Expected)
+			}
+		})
+	}
+}
+
+func TestNewGitHubConfig(t *testing.T) {
+	server := testGitHubServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The token and endpoint come from the config rather than the environment
+	defer restoreEnv("GITHUB_TOKEN")()
+	os.Unsetenv("GITHUB_TOKEN")
+
+	g, err := NewGitHubConfig(Config{Org: "test", Token: "test", BaseURL: server.URL, CacheDir: dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer g.Close()
+	checkLogins(t, "TestNewGitHubConfig", g.GetMembers(), []string{"test1", "test2"})
+}
@@ -1,12 +1,20 @@
 package directory
 
 import (
+	"encoding/json"
 	"os"
 	"sort"
+	"strings"
+	"time"
 )
 
 const (
 	cacheTTL = 60.0 // 60 minute TTL
+
+	// MemberStateActive is the state of members who have joined the organization
+	MemberStateActive = "active"
+	// MemberStatePending is the state of members who were invited but haven't joined yet
+	MemberStatePending = "pending"
 )
 
 var cacheDir = os.ExpandEnv("${HOME}/.psst/cache")
@@ -29,24 +37,169 @@ type Info struct {
 	ActiveMemberTeams []string
 	Members           []Member
 	Teams             []Team
+	Metadata          OrgMetadata
+}
+
+// OrgMetadata holds details of the organization itself. Fields GitHub only shows to org owners are
+// empty for other tokens.
+type OrgMetadata struct {
+	// DefaultRepoPermission is the base permission members have on the org's repositories: read,
+	// write, admin or none
+	DefaultRepoPermission string `json:"defaultRepoPermission,omitempty"`
 }
 
-// Member contains basic info about a member
+// Member contains basic info about a member. The JSON field names are part of psst's output format
+// and only differ in case from the Go names so caches written before they were added still decode.
 type Member struct {
-	Login string
-	Name  string
+	// ID is GitHub's numeric user ID, which unlike the login doesn't change when an account is renamed.
+	// Members cached before it was recorded have an ID of zero until the cache is refreshed.
+	ID        int64  `json:"id,omitempty"`
+	Login     string `json:"login"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatarUrl,omitempty"`
+	State     string `json:"state,omitempty"`
+	Company   string `json:"company,omitempty"`
+	Location  string `json:"location,omitempty"`
+	// Email is the member's public email, which is empty for most members
+	Email string `json:"email,omitempty"`
+	// SAMLNameID is the member's corporate username from the org's SAML identity provider. It is only
+	// filled in with WithSAMLIdentities.
+	SAMLNameID string `json:"samlNameId,omitempty"`
+	// Orgs are the orgs the member belongs to. It is only filled in by MergeDirectories.
+	Orgs []string `json:"orgs,omitempty"`
+	// PreviousLogins are logins the member was seen with before renaming their account, oldest first
+	PreviousLogins []string `json:"previousLogins,omitempty"`
+	// LastActive is when the member's profile was last updated. GitHub doesn't expose when someone last
+	// signed in, so this is only a rough hint of activity. It is nil for pending members and members
+	// cached before it was recorded.
+	LastActive *time.Time `json:"lastActive,omitempty"`
+	// SSHKeys are the member's public SSH keys and GPGKeys their GPG keys. They are only filled in by
+	// EnrichMembers.
+	SSHKeys []string `json:"sshKeys,omitempty"`
+	GPGKeys []GPGKey `json:"gpgKeys,omitempty"`
+	// Annotations hold data from the caller's own systems, such as an employee ID, added by the
+	// WithMemberHook hook. They are cached along with the rest of the member.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// GPGKey is a GPG key a member added to their GitHub account
+type GPGKey struct {
+	KeyID string `json:"keyId"`
+	// PublicKey is the key as GitHub returns it
+	PublicKey string `json:"publicKey"`
+	// CanEncrypt is true when the key or one of its subkeys may be used to encrypt
+	CanEncrypt bool `json:"canEncrypt,omitempty"`
 }
 
 // Team contains basic info about Team or group
 type Team struct {
-	Name    string
-	Members []string
+	ID   int64  `json:"id,omitempty"`
+	Name string `json:"name"`
+	Slug string `json:"slug,omitempty"`
+	// Org is the org the team belongs to. It is only filled in by MergeDirectories.
+	Org string `json:"org,omitempty"`
+	// ParentID is the ID of the team this one is nested under, zero for top level teams
+	ParentID int64    `json:"parentId,omitempty"`
+	Members  []string `json:"members"`
+	// Maintainers are the members who can manage the team. It is only filled in with WithTeamMaintainers.
+	Maintainers []string `json:"maintainers,omitempty"`
+	// Repos are the names of the organization's repositories the team can access. It is only filled in
+	// with WithTeamRepos.
+	Repos []string `json:"repos,omitempty"`
+	// Secret is true for teams only visible to their members and organization owners
+	Secret bool `json:"secret,omitempty"`
+	// Empty is true when the team has no members, so sharing with it would reach nobody
+	Empty bool `json:"empty,omitempty"`
+	// Incomplete is true when some of the team's details couldn't be fetched, such as the members of a
+	// secret team the token can't see. Members is empty when they couldn't be fetched, without the team
+	// being marked Empty, and the team doesn't resolve as a recipient. A fetch with incomplete teams isn't
+	// cached.
+	Incomplete bool `json:"incomplete,omitempty"`
+	// CreatedAt and UpdatedAt are only filled in with WithTeamTimes and are nil otherwise
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
 }
 
 // Matches allows us to return both usernames and team names as single type
 type Matches struct {
-	Members []Member
-	Teams   []Team
+	Members []Member `json:"members"`
+	Teams   []Team   `json:"teams"`
+	// ExactMembers and ExactTeams are the matches that equal the lookup rather than contain it: members
+	// with a searched field such as the login equal to it and teams with it as their name or slug. They
+	// are also in Members and Teams.
+	ExactMembers []Member `json:"exactMembers,omitempty"`
+	ExactTeams   []Team   `json:"exactTeams,omitempty"`
+}
+
+// Partial returns the matches that only contain the lookup, leaving out the exact ones
+func (m Matches) Partial() Matches {
+	partial := Matches{Members: []Member{}, Teams: []Team{}}
+	for _, member := range m.Members {
+		if !containsMember(m.ExactMembers, member) {
+			partial.Members = append(partial.Members, member)
+		}
+	}
+	for _, t := range m.Teams {
+		if !containsTeam(m.ExactTeams, t) {
+			partial.Teams = append(partial.Teams, t)
+		}
+	}
+	return partial
+}
+
+func containsMember(members []Member, m Member) bool {
+	for _, member := range members {
+		if member.Login == m.Login {
+			return true
+		}
+	}
+	return false
+}
+
+func containsTeam(teams []Team, t Team) bool {
+	for _, team := range teams {
+		if team.Name == t.Name && team.Slug == t.Slug {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalJSON encodes matches as an object with "members" and "teams" arrays, which are empty rather
+// than null when nothing matched. The exact matches are only included when there are any.
+func (m Matches) MarshalJSON() ([]byte, error) {
+	// The alias drops the MarshalJSON method so this doesn't recurse
+	type matches Matches
+	out := matches(m)
+	if out.Members == nil {
+		out.Members = []Member{}
+	}
+	out.Teams = jsonTeams(m.Teams)
+	if len(m.ExactTeams) > 0 {
+		out.ExactTeams = jsonTeams(m.ExactTeams)
+	}
+	return json.Marshal(out)
+}
+
+// jsonTeams copies the teams so filling in empty member lists doesn't change the caller's slice
+func jsonTeams(teams []Team) []Team {
+	out := make([]Team, len(teams))
+	copy(out, teams)
+	for i, t := range out {
+		if t.Members == nil {
+			out[i].Members = []string{}
+		}
+	}
+	return out
+}
+
+// sort orders members by login and teams by name so results are the same across runs. Empty teams
+// come after the rest since they are rarely what's being looked for.
+func (m Matches) sort() {
+	ByMembers(sortMemberLogins).Sort(m.Members)
+	ByTeams(sortEmptyTeamsLast).Sort(m.Teams)
+	ByMembers(sortMemberLogins).Sort(m.ExactMembers)
+	ByTeams(sortEmptyTeamsLast).Sort(m.ExactTeams)
 }
 
 // ByMembers is the type of a "less" function that defines the ordering of its Member arguments.
@@ -56,6 +209,17 @@ var sortMemberLogins = func(m1, m2 *Member) bool {
 	return m1.Login < m2.Login
 }
 
+var sortMemberNames = func(m1, m2 *Member) bool {
+	if (m1.Name == "") != (m2.Name == "") {
+		return m2.Name == ""
+	}
+	n1, n2 := strings.ToLower(m1.Name), strings.ToLower(m2.Name)
+	if n1 != n2 {
+		return n1 < n2
+	}
+	return m1.Login < m2.Login
+}
+
 // Sort is a method on the function type, By, that sorts the argument slice according to the function.
 func (by ByMembers) Sort(members []Member) {
 	ms := &memberSorter{
@@ -93,6 +257,20 @@ var sortTeamNames = func(t1, t2 *Team) bool {
 	return t1.Name < t2.Name
 }
 
+var sortTeamSizes = func(t1, t2 *Team) bool {
+	if len(t1.Members) != len(t2.Members) {
+		return len(t1.Members) > len(t2.Members)
+	}
+	return t1.Name < t2.Name
+}
+
+var sortEmptyTeamsLast = func(t1, t2 *Team) bool {
+	if t1.Empty != t2.Empty {
+		return t2.Empty
+	}
+	return t1.Name < t2.Name
+}
+
 // Sort is a method on the function type, By, that sorts the argument slice according to the function.
 func (by ByTeams) Sort(teams []Team) {
 	ms := &teamSorter{
@@ -0,0 +1,54 @@
+package directory
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMatchesMarshalJSON(t *testing.T) {
+	cases := map[string]struct {
+		Matches  Matches
+		Expected string
+	}{
+		"TestEmpty": {
+			Matches:  Matches{},
+			Expected: `{"members":[],"teams":[]}`,
+		},
+		"TestMembersAndTeams": {
+			Matches: Matches{
+				Members: []Member{Member{Login: "test1", Name: "Test 1", State: MemberStateActive}},
+				Teams:   []Team{Team{Name: "team1", Slug: "team1"}},
+			},
+			Expected: `{"members":[{"login":"test1","name":"Test 1","state":"active"}],"teams":[{"name":"team1","slug":"team1","members":[]}]}`,
+		},
+		"TestExact": {
+			Matches: Matches{
+				Teams:      []Team{Team{Name: "team1"}},
+				ExactTeams: []Team{Team{Name: "team1"}},
+			},
+			Expected: `{"members":[],"teams":[{"name":"team1","members":[]}],"exactTeams":[{"name":"team1","members":[]}]}`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := json.Marshal(c.Matches)
+			if err != nil {
+				t.Fatalf("Name: %s, unexpected error: %v", name, err)
+			}
+			if string(got) != c.Expected {
+				t.Errorf("Name: %s, got: %s, expected: %s", name, got, c.Expected)
+			}
+		})
+	}
+}
+
+func TestMemberDecodesUntaggedJSON(t *testing.T) {
+	m := Member{}
+	if err := json.Unmarshal([]byte(`{"Login":"test1","Name":"Test 1","AvatarURL":"https://example.com/a.png"}`), &m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Login != "test1" || m.Name != "Test 1" || m.AvatarURL != "https://example.com/a.png" {
+		t.Errorf("got: %+v, expected fields from the untagged format", m)
+	}
+}
@@ -0,0 +1,152 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// EnrichMembers looks up the public SSH keys, GPG keys and public email of each member, such as the
+// recipients of a secret before encrypting for them. The lookups run concurrently on as many workers as
+// WithWorkers allows and are retried like every other API call. The enriched copies are returned in
+// the same order, the members passed in and the loaded directory are left alone. A member whose
+// account no longer exists is returned as is. The first failed lookup cancels the rest and is returned.
+func (g *GH) EnrichMembers(ctx context.Context, members []Member) ([]Member, error) {
+	enriched := make([]Member, len(members))
+	copy(enriched, members)
+
+	in := make(chan int)
+	grp, grpCtx := errgroup.WithContext(ctx)
+	for i := 0; i < g.opts.workers; i++ {
+		delay := g.workerDelay()
+		grp.Go(func() error {
+			select {
+			case <-grpCtx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+
+			// Every worker fills in its own members, so the slice needs no locking
+			for i := range in {
+				if err := g.enrichMember(grpCtx, &enriched[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	func() {
+		for i := range enriched {
+			select {
+			case in <- i:
+			case <-grpCtx.Done():
+				return
+			}
+		}
+	}()
+	close(in)
+
+	if err := grp.Wait(); err != nil {
+		return nil, errors.Wrap(err, "error enriching members")
+	}
+	// Sending stops early without an error of its own when ctx is cancelled
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "error enriching members")
+	}
+	return enriched, nil
+}
+
+// enrichMember fills in the keys and email of a single member
+func (g *GH) enrichMember(ctx context.Context, m *Member) error {
+	var u *github.User
+	_, err := g.callAPI(ctx, "get_user", func(ctx context.Context) (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		u, resp, err = g.UsersService.Get(ctx, m.Login)
+		return resp, err
+	})
+	if StatusCode(err) == http.StatusNotFound {
+		g.logf("member %s no longer exists, not enriching", m.Login)
+		return nil
+	}
+	if err != nil {
+		return g.wrapError(err, fmt.Sprintf("error looking up member %s", m.Login))
+	}
+	m.Email = strings.TrimSpace(u.GetEmail())
+
+	if m.SSHKeys, err = g.getSSHKeys(ctx, m.Login); err != nil {
+		return g.wrapError(err, fmt.Sprintf("error looking up SSH keys of member %s", m.Login))
+	}
+	if m.GPGKeys, err = g.getGPGKeys(ctx, m.Login); err != nil {
+		return g.wrapError(err, fmt.Sprintf("error looking up GPG keys of member %s", m.Login))
+	}
+	return nil
+}
+
+func (g *GH) getSSHKeys(ctx context.Context, login string) ([]string, error) {
+	keys := []string{}
+	nextPage := 1
+
+	for nextPage > 0 {
+		var ks []*github.Key
+		resp, err := g.listPage(ctx, "list_ssh_keys", func(ctx context.Context) (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			ks, resp, err = g.ghClient.Users.ListKeys(ctx, login, &github.ListOptions{Page: nextPage, PerPage: g.opts.perPage})
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range ks {
+			keys = append(keys, k.GetKey())
+		}
+		nextPage = resp.NextPage
+	}
+
+	return keys, nil
+}
+
+func (g *GH) getGPGKeys(ctx context.Context, login string) ([]GPGKey, error) {
+	keys := []GPGKey{}
+	nextPage := 1
+
+	for nextPage > 0 {
+		var ks []*github.GPGKey
+		resp, err := g.listPage(ctx, "list_gpg_keys", func(ctx context.Context) (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			ks, resp, err = g.ghClient.Users.ListGPGKeys(ctx, login, &github.ListOptions{Page: nextPage, PerPage: g.opts.perPage})
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range ks {
+			keys = append(keys, GPGKey{KeyID: k.GetKeyID(), PublicKey: k.GetPublicKey(), CanEncrypt: canEncrypt(*k)})
+		}
+		nextPage = resp.NextPage
+	}
+
+	return keys, nil
+}
+
+// canEncrypt reports whether the key or any of its subkeys may encrypt
+func canEncrypt(k github.GPGKey) bool {
+	if k.GetCanEncryptComms() || k.GetCanEncryptStorage() {
+		return true
+	}
+	for _, sub := range k.Subkeys {
+		if canEncrypt(sub) {
+			return true
+		}
+	}
+	return false
+}
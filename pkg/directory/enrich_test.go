@@ -0,0 +1,86 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func TestEnrichMembers(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		switch r.URL.Path {
+		case "/users/test1":
+			fmt.Fprint(w, `{"login":"test1","email":"test1@example.com"}`)
+		case "/users/test1/keys":
+			fmt.Fprint(w, `[{"id":1,"key":"ssh-ed25519 AAAA1"},{"id":2,"key":"ssh-rsa AAAA2"}]`)
+		case "/users/test1/gpg_keys":
+			fmt.Fprint(w, `[{"key_id":"ABC","public_key":"xsBN","can_encrypt_storage":false,"subkeys":[{"key_id":"DEF","can_encrypt_storage":true}]}]`)
+		case "/users/test2", "/users/test3", "/users/test4":
+			fmt.Fprintf(w, `{"login":"%s"}`, r.URL.Path[len("/users/"):])
+		case "/users/test2/keys", "/users/test2/gpg_keys", "/users/test3/keys", "/users/test3/gpg_keys", "/users/test4/keys", "/users/test4/gpg_keys":
+			fmt.Fprint(w, `[]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+	g.Org = "test"
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+	g.UsersService = g.ghClient.Users
+	WithWorkers(2)(&g.opts)
+	WithLogger(&testLogger{})(&g.opts)
+
+	members := []Member{{Login: "test1"}, {Login: "test2", Email: "old@example.com"}, {Login: "gone"}, {Login: "test3"}, {Login: "test4"}}
+	enriched, err := g.EnrichMembers(context.Background(), members)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkLogins(t, "TestEnrichMembersOrder", enriched, []string{"test1", "test2", "gone", "test3", "test4"})
+
+	m := enriched[0]
+	if m.Email != "test1@example.com" || len(m.SSHKeys) != 2 || m.SSHKeys[0] != "ssh-ed25519 AAAA1" {
+		t.Errorf("got: %+v, expected the email and SSH keys of test1", m)
+	}
+	if len(m.GPGKeys) != 1 || m.GPGKeys[0].KeyID != "ABC" || m.GPGKeys[0].PublicKey != "xsBN" || !m.GPGKeys[0].CanEncrypt {
+		t.Errorf("got: %+v, expected the GPG key of test1 able to encrypt through its subkey", m.GPGKeys)
+	}
+	if enriched[1].Email != "" || len(enriched[1].SSHKeys) != 0 {
+		t.Errorf("got: %+v, expected test2 to have no email or keys", enriched[1])
+	}
+	if members[0].Email != "" || members[1].Email != "old@example.com" {
+		t.Errorf("got: %+v, expected the members passed in to be left alone", members)
+	}
+	if maxInFlight > 2 {
+		t.Errorf("got: %d, expected at most 2 requests at a time", maxInFlight)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.EnrichMembers(ctx, members); err == nil {
+		t.Errorf("expected an error with a cancelled context")
+	}
+}
@@ -0,0 +1,59 @@
+package directory
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+// wrapError wraps err with message. With verbose errors enabled it also includes the message, field
+// errors and documentation link GitHub returned so failures like missing admin rights are actionable.
+func (g *GH) wrapError(err error, message string) error {
+	if !g.opts.verboseErrors {
+		return errors.Wrap(err, message)
+	}
+
+	ghErr, ok := errors.Cause(err).(*github.ErrorResponse)
+	if !ok {
+		return errors.Wrap(err, message)
+	}
+	return errors.Wrap(err, fmt.Sprintf("%s (%s)", message, describeErrorResponse(ghErr)))
+}
+
+// StatusCode returns the HTTP status GitHub responded with when err came from a failed API call, such as
+// 401 for a bad token, 403 for a missing scope or 404 for an unknown org. It is 0 for other errors.
+func StatusCode(err error) int {
+	var resp *http.Response
+	switch e := errors.Cause(err).(type) {
+	case *github.ErrorResponse:
+		resp = e.Response
+	case *github.RateLimitError:
+		resp = e.Response
+	case *github.AbuseRateLimitError:
+		resp = e.Response
+	}
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+func describeErrorResponse(ghErr *github.ErrorResponse) string {
+	details := []string{}
+	if ghErr.Response != nil {
+		details = append(details, fmt.Sprintf("status %d", ghErr.Response.StatusCode))
+	}
+	if ghErr.Message != "" {
+		details = append(details, fmt.Sprintf("GitHub said %q", ghErr.Message))
+	}
+	for _, e := range ghErr.Errors {
+		details = append(details, fmt.Sprintf("%s.%s: %s", e.Resource, e.Field, e.Code))
+	}
+	if ghErr.DocumentationURL != "" {
+		details = append(details, fmt.Sprintf("see %s", ghErr.DocumentationURL))
+	}
+	return strings.Join(details, ", ")
+}
@@ -0,0 +1,107 @@
+package directory
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+func testErrorResponse(status int, message string) *github.ErrorResponse {
+	u, _ := url.Parse("https://api.github.com/orgs/test/members")
+	return &github.ErrorResponse{
+		Response:         &http.Response{StatusCode: status, Request: &http.Request{Method: "GET", URL: u}},
+		Message:          message,
+		DocumentationURL: "https://developer.github.com/v3/orgs/members/",
+	}
+}
+
+func TestWrapError(t *testing.T) {
+	ghErr := testErrorResponse(http.StatusForbidden, "Must have admin rights")
+
+	cases := map[string]struct {
+		State    *GH
+		Err      error
+		Contains []string
+		Missing  []string
+	}{
+		"TestVerbose": {
+			State:    &GH{opts: options{verboseErrors: true}},
+			Err:      ghErr,
+			Contains: []string{"unable to get members", "status 403", `"Must have admin rights"`, "see https://developer.github.com/v3/orgs/members/"},
+		},
+		"TestNotVerbose": {
+			State:    &GH{},
+			Err:      ghErr,
+			Contains: []string{"unable to get members"},
+			Missing:  []string{"see https://developer.github.com"},
+		},
+		"TestVerboseOtherError": {
+			State:    &GH{opts: options{verboseErrors: true}},
+			Err:      errors.New("connection reset"),
+			Contains: []string{"unable to get members: connection reset"},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := c.State.wrapError(c.Err, "unable to get members")
+			if errors.Cause(got) != c.Err {
+				t.Errorf("Name: %s, cause was not preserved: %v", name, got)
+			}
+			for _, s := range c.Contains {
+				if !strings.Contains(got.Error(), s) {
+					t.Errorf("Name: %s, got: %q, expected it to contain %q", name, got, s)
+				}
+			}
+			for _, s := range c.Missing {
+				if strings.Contains(got.Error(), s) {
+					t.Errorf("Name: %s, got: %q, expected it not to contain %q", name, got, s)
+				}
+			}
+		})
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	cases := map[string]struct {
+		Err      error
+		Expected int
+	}{
+		"TestErrorResponse": {
+			Err:      testErrorResponse(http.StatusNotFound, "Not Found"),
+			Expected: http.StatusNotFound,
+		},
+		"TestWrapped": {
+			Err:      errors.Wrap(errors.Wrap(testErrorResponse(http.StatusUnauthorized, "Bad credentials"), "unable to get members"), "unable to get members or teams from GitHub"),
+			Expected: http.StatusUnauthorized,
+		},
+		"TestVerbose": {
+			Err:      (&GH{opts: options{verboseErrors: true}}).wrapError(testErrorResponse(http.StatusForbidden, "Must have admin rights"), "unable to get pending members"),
+			Expected: http.StatusForbidden,
+		},
+		"TestRateLimit": {
+			Err:      &github.RateLimitError{Response: &http.Response{StatusCode: http.StatusForbidden}},
+			Expected: http.StatusForbidden,
+		},
+		"TestOtherError": {
+			Err:      errors.New("GITHUB_TOKEN not set"),
+			Expected: 0,
+		},
+		"TestNil": {
+			Err:      nil,
+			Expected: 0,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := StatusCode(c.Err); got != c.Expected {
+				t.Errorf("Name: %s, got: %d, expected: %d", name, got, c.Expected)
+			}
+		})
+	}
+}
@@ -2,12 +2,17 @@ package directory
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/cipher"
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/go-github/github"
@@ -17,11 +22,15 @@ import (
 )
 
 const (
-	// GHAllTeam containing all users in GitHub
+	// GHAllTeam containing all users in GitHub. Like "*", resolving it as a recipient gives every member,
+	// unless a member or team is named GHAllTeam.
 	GHAllTeam = "all"
 
 	ghWorkers      = 10
+	ghPerPage      = 100
 	contextTimeout = 2 * time.Second
+	// ghWorkerJitter is the longest a member lookup worker waits before its first request
+	ghWorkerJitter = 100 * time.Millisecond
 )
 
 // UsersService holds methods used in the GitHub UsersService for easier testing
@@ -29,175 +38,571 @@ type UsersService interface {
 	Get(context.Context, string) (*github.User, *github.Response, error)
 }
 
+// ghClient lets GH embed the go-github client under a name that doesn't collide with the Client method
+type ghClient = github.Client
+
 // GH hosts a client for accessing GH as well as cached Member and Team lists
 type GH struct {
-	*github.Client
+	// The client is embedded so its services, such as Organizations or Users, stay reachable directly
+	// on GH. New code should go through Client instead.
+	*ghClient
 
 	UsersService UsersService
 	Info
+
+	opts        options
+	cacheCipher cipher.AEAD
+	// throttle is only set with WithAdaptiveWorkers
+	throttle *throttle
+
+	// memberTeams is a reverse index of lowercase member logins to team names
+	memberTeams map[string][]string
+
+	// mu guards the members, teams and indexes, which may be replaced by a background refresh.
+	// Read them through the accessor methods rather than the embedded Info when refreshing.
+	mu sync.RWMutex
+	// refreshMu keeps refreshes from running at the same time
+	refreshMu   sync.Mutex
+	lastRefresh RefreshResult
+
+	transport *http.Transport
+	// done is closed by Close to stop any background work
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 // NewGitHub returns an initialized GitHub client to the caller and stored GH members and teams
-func NewGitHub(org string, updateCache bool) (*GH, error) {
-	ctx := context.Background()
-	client := &GH{}
+func NewGitHub(org string, updateCache bool, opts ...Option) (*GH, error) {
+	if updateCache {
+		opts = append(opts[:len(opts):len(opts)], WithForceRefresh())
+	}
+	return NewGitHubContext(context.Background(), org, opts...)
+}
 
-	token, ok := os.LookupEnv("GITHUB_TOKEN")
-	if !ok {
-		return client, errors.New("GITHUB_TOKEN not set")
+// NewGitHubContext is like NewGitHub but uses the provided context for the initial fetch of members and
+// teams, so it can be cancelled or given a deadline. Use WithForceRefresh to skip the cache.
+func NewGitHubContext(ctx context.Context, org string, opts ...Option) (*GH, error) {
+	client := &GH{opts: defaultOptions(), done: make(chan struct{})}
+	for _, opt := range opts {
+		opt(&client.opts)
+	}
+	if client.opts.adaptive {
+		client.throttle = newThrottle(client.opts.workers)
+	}
+
+	token, err := client.token()
+	if err != nil {
+		return client, err
 	}
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: token},
 	)
-	tc := oauth2.NewClient(ctx, ts)
-	client.Client = github.NewClient(tc)
-	client.UsersService = client.Client.Users
+	// Keep our own transport so Close can release its idle connections
+	client.transport = http.DefaultTransport.(*http.Transport).Clone()
+	if client.opts.insecureTLS {
+		client.transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	tc := oauth2.NewClient(context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: client.transport}), ts)
+	client.ghClient = github.NewClient(tc)
+	client.UsersService = client.ghClient.Users
 	client.Org = org
 
-	if err := client.getMembersAndTeams(updateCache); err != nil {
+	if err := client.setupCacheEncryption(); err != nil {
+		return client, err
+	}
+
+	baseURL, err := apiBaseURL()
+	if client.opts.baseURL != "" {
+		if baseURL, err = parseBaseURL(client.opts.baseURL); err != nil {
+			err = errors.Wrap(err, "unable to parse GitHub API URL")
+		}
+	}
+	if err != nil {
+		return client, err
+	}
+	if baseURL != nil {
+		client.ghClient.BaseURL = baseURL
+	}
+
+	if _, err := client.getMembersAndTeams(ctx, client.opts.forceRefresh); err != nil {
 		return client, err
 	}
 	return client, nil
 }
 
-func (g *GH) getMembersAndTeams(updateCache bool) error {
-	update := updateCache
+// WarmCache fills the on-disk cache for an org, fetching from GitHub unless the cache is still fresh,
+// without keeping a client around. Later runs with the same options then start from the cache.
+func WarmCache(org string, opts ...Option) error {
+	return WarmCacheContext(context.Background(), org, opts...)
+}
 
-	if err := os.MkdirAll(cacheDir, os.ModePerm); err != nil {
-		return errors.Wrap(err, "unable to create cache directory")
+// WarmCacheContext is like WarmCache but uses the provided context for the API calls
+func WarmCacheContext(ctx context.Context, org string, opts ...Option) error {
+	client, err := NewGitHubContext(ctx, org, opts...)
+	client.Close()
+	return err
+}
+
+// token returns the GitHub token from WithToken, the WithTokenFile file or, without either, GITHUB_TOKEN
+func (g *GH) token() (string, error) {
+	if g.opts.token != "" {
+		return g.opts.token, nil
+	}
+	if g.opts.tokenFile == "" {
+		token, ok := os.LookupEnv("GITHUB_TOKEN")
+		if !ok {
+			return "", errors.New("GITHUB_TOKEN not set")
+		}
+		return token, nil
 	}
 
-	membersFile := filepath.Join(cacheDir, "members")
-	mfInfo, err := os.Stat(membersFile)
-	if err != nil || time.Since(mfInfo.ModTime()).Minutes() > cacheTTL {
-		update = true
+	buf, err := ioutil.ReadFile(g.opts.tokenFile)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to read GitHub token file")
+	}
+	token := strings.TrimSpace(string(buf))
+	if token == "" {
+		return "", fmt.Errorf("GitHub token file %s is empty", g.opts.tokenFile)
 	}
+	return token, nil
+}
 
-	teamsFile := filepath.Join(cacheDir, "teams")
-	tfInfo, err := os.Stat(teamsFile)
-	if err != nil || time.Since(tfInfo.ModTime()).Minutes() > cacheTTL {
-		update = true
+// Close stops background work started by the client and closes idle connections. The client's
+// cached data can still be read afterwards but no further API calls should be made.
+func (g *GH) Close() error {
+	g.closeOnce.Do(func() {
+		if g.done != nil {
+			close(g.done)
+		}
+		if g.transport != nil {
+			g.transport.CloseIdleConnections()
+		}
+	})
+	return nil
+}
+
+// Client returns the authenticated go-github client used by the directory, for API calls psst doesn't
+// wrap itself. It shares the directory's token and connection pool.
+func (g *GH) Client() *github.Client {
+	return g.ghClient
+}
+
+func (g *GH) logf(format string, v ...interface{}) {
+	if g.opts.logger != nil {
+		g.opts.logger.Printf(format, v...)
 	}
+}
 
-	activeMembershipsFile := filepath.Join(cacheDir, "active-memberships")
-	amfInfo, err := os.Stat(activeMembershipsFile)
-	if err != nil || time.Since(amfInfo.ModTime()).Minutes() > cacheTTL {
-		update = true
+// apiBaseURL returns the API endpoint from GITHUB_API_URL, which GitHub Actions sets for both
+// github.com and enterprise runners. A nil URL means the go-github default should be used.
+func apiBaseURL() (*url.URL, error) {
+	apiURL := os.Getenv("GITHUB_API_URL")
+	if apiURL == "" {
+		return nil, nil
+	}
+	baseURL, err := parseBaseURL(apiURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse GITHUB_API_URL")
+	}
+	return baseURL, nil
+}
+
+// parseBaseURL parses an API endpoint for use as the go-github BaseURL
+func parseBaseURL(apiURL string) (*url.URL, error) {
+	// go-github requires the BaseURL to have a trailing slash
+	if !strings.HasSuffix(apiURL, "/") {
+		apiURL += "/"
+	}
+	return url.Parse(apiURL)
+}
+
+// pageContext bounds a single list call. With an overall timeout from WithTimeout the whole operation
+// shares that budget instead, so pages aren't limited separately.
+func (g *GH) pageContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if g.opts.timeout > 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, contextTimeout)
+}
+
+func (g *GH) getMembersAndTeams(ctx context.Context, updateCache bool) (RefreshResult, error) {
+	start := time.Now()
+	update := updateCache
+
+	if g.opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.opts.timeout)
+		defer cancel()
+	}
+
+	if g.opts.cacheStore == nil {
+		if err := g.createCacheDir(); err != nil {
+			return RefreshResult{}, err
+		}
+		if err := g.updateCacheManifest(); err != nil {
+			return RefreshResult{}, err
+		}
+	}
+	// Held until the fetched results are saved, so a process waiting on it loads them from the cache
+	unlock, err := g.lockCache()
+	if err != nil {
+		return RefreshResult{}, err
+	}
+	defer unlock()
+
+	membersFile := g.cacheFile(membersCacheFile)
+	teamsFile := g.cacheFile(teamsCacheFile)
+	activeMembershipsFile := g.cacheFile(activeMembershipsCacheFile)
+	orgFile := g.cacheFile(orgCacheFile)
+	directoryFile := g.cacheFile(directoryCacheFile)
+	files := []string{membersFile, teamsFile, activeMembershipsFile}
+	if g.opts.singleFileCache {
+		files = []string{directoryFile}
+	}
+	for _, file := range files {
+		if g.cacheExpired(file) {
+			update = true
+		}
+	}
+
+	info := Info{Org: g.Org}
+	membersCapped, teamsCapped := false, false
+	if !update {
+		getCached := func() error { return g.getCachedInfo(&info, membersFile, teamsFile, activeMembershipsFile, orgFile) }
+		if g.opts.singleFileCache {
+			getCached = func() error { return g.getCachedDirectory(&info, directoryFile) }
+		}
+		if err := getCached(); err != nil {
+			// A store without modification times only finds out a key is missing when loading it
+			if cause := errors.Cause(err); cause != errCacheMiss && !os.IsNotExist(cause) {
+				return RefreshResult{}, err
+			}
+			update = true
+		} else {
+			g.count(MetricCacheHit, "all")
+			g.setInfo(info)
+		}
 	}
 
 	if update {
-		grp, _ := errgroup.WithContext(context.Background())
+		g.count(MetricCacheMiss, "all")
+		fetchStart := time.Now()
+		info = Info{Org: g.Org}
+		// Either fetch failing cancels the other
+		grp, grpCtx := errgroup.WithContext(ctx)
 		grp.Go(func() error {
-			if err := g.getMembers(); err != nil {
+			members, activeMemberTeams, capped, err := g.getMembers(grpCtx)
+			if err != nil {
 				return err
 			}
+			info.Members = members
+			info.ActiveMemberTeams = activeMemberTeams
+			membersCapped = capped
 			return nil
 		})
 
 		grp.Go(func() error {
-			if err := g.getTeams(); err != nil {
+			teams, capped, err := g.getTeams(grpCtx)
+			if err != nil {
 				return err
 			}
+			info.Teams = teams
+			teamsCapped = capped
+			return nil
+		})
+
+		grp.Go(func() error {
+			metadata, err := g.getOrgMetadata(grpCtx)
+			if err != nil {
+				// The org details only inform policy decisions, the directory is usable without them
+				g.logf("unable to get details of organization %s: %v", g.Org, err)
+				return nil
+			}
+			info.Metadata = metadata
 			return nil
 		})
 
 		if err := grp.Wait(); err != nil {
-			return errors.Wrap(err, "unable to get members or teams from GitHub")
+			return RefreshResult{}, errors.Wrap(err, "unable to get members or teams from GitHub")
 		}
+		g.since("all", fetchStart)
 
-		if err := saveCache(membersFile, g.Members); err != nil {
-			return errors.Wrap(err, "unable to save members file")
-		}
-		if err := saveCache(teamsFile, g.Info.Teams); err != nil {
-			return errors.Wrap(err, "unable to save teams file")
-		}
-		if err := saveCache(activeMembershipsFile, g.ActiveMemberTeams); err != nil {
-			return errors.Wrap(err, "unable to save active memberships file")
-		}
-	} else {
-		if err := getCached(membersFile, &g.Members); err != nil {
-			return errors.Wrap(err, "unable to get cached members information")
-		}
-		if err := getCached(teamsFile, &g.Info.Teams); err != nil {
-			return errors.Wrap(err, "unable to get cached team information")
+		info.Teams = g.trackRenames(g.previousMembers(), info.Members, info.Teams)
+		g.setInfo(info)
+
+		save := func() error { return g.saveInfo(info, membersFile, teamsFile, activeMembershipsFile, orgFile) }
+		if g.opts.singleFileCache {
+			save = func() error { return g.saveDirectory(info, directoryFile) }
 		}
-		if err := getCached(activeMembershipsFile, &g.ActiveMemberTeams); err != nil {
-			return errors.Wrap(err, "unable to get cached active memberships information")
+		if err := save(); err != nil {
+			return RefreshResult{}, err
 		}
 	}
 
-	return nil
+	result := RefreshResult{
+		MembersFetched:  len(info.Members),
+		TeamsFetched:    len(info.Teams),
+		Duration:        time.Since(start),
+		FromCache:       !update,
+		MembersCapped:   membersCapped,
+		TeamsCapped:     teamsCapped,
+		IncompleteTeams: incompleteTeams(info.Teams),
+	}
+	g.mu.Lock()
+	g.lastRefresh = result
+	g.mu.Unlock()
+
+	return result, nil
 }
 
-func saveCache(filename string, v interface{}) error {
-	buf, err := json.Marshal(v)
-	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("unable to marshal cache file %s", filename))
+// LastRefresh returns the summary of the most recent load of members and teams, whether it came from
+// NewGitHub, Refresh or a background refresh
+func (g *GH) LastRefresh() RefreshResult {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.lastRefresh
+}
+
+// FromCache reports whether the current members and teams were loaded from the cache or a snapshot
+// rather than fetched from GitHub by the most recent load
+func (g *GH) FromCache() bool {
+	return g.LastRefresh().FromCache
+}
+
+// setInfo swaps in a freshly loaded set of members and teams. Readers holding the previous slices
+// keep seeing consistent data since they are replaced rather than modified.
+func (g *GH) setInfo(info Info) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Caches written before Empty existed don't have it set. Incomplete teams may be missing their
+	// members, so they keep Empty as it was fetched.
+	for i := range info.Teams {
+		if !info.Teams[i].Incomplete {
+			info.Teams[i].Empty = len(info.Teams[i].Members) == 0
+		}
 	}
+	// The cache may have been written by a client sorting members differently, and the sort isn't part
+	// of the cache id
+	ByMembers(g.memberLess()).Sort(info.Members)
+
+	g.Members = info.Members
+	g.Info.Teams = info.Teams
+	g.ActiveMemberTeams = info.ActiveMemberTeams
+	g.Metadata = info.Metadata
+	g.indexTeams()
+}
 
-	if _, err := os.Stat(filename); os.IsExist(err) {
-		if err := os.Remove(filename); err != nil {
-			return err
+// membersMissing is true for a team kept after its members couldn't be fetched
+func membersMissing(t Team) bool {
+	return t.Incomplete && !t.Empty && len(t.Members) == 0
+}
+
+// incompleteTeams counts the teams with missing details
+func incompleteTeams(teams []Team) int {
+	n := 0
+	for _, t := range teams {
+		if t.Incomplete {
+			n++
 		}
 	}
+	return n
+}
+
+// RefreshResult summarizes a load of members and teams
+type RefreshResult struct {
+	MembersFetched int
+	TeamsFetched   int
+	Duration       time.Duration
+	// FromCache is true when the cache was still fresh and nothing was fetched from GitHub
+	FromCache bool
+	// MembersCapped and TeamsCapped are true when WithMaxMembers or WithMaxTeams stopped the fetch
+	// before everything was listed. Hitting a limit isn't an error.
+	MembersCapped bool
+	TeamsCapped   bool
+	// IncompleteTeams is the number of teams whose members or other details couldn't be fetched, see
+	// Team.Incomplete
+	IncompleteTeams int
+}
 
-	if err := ioutil.WriteFile(filename, buf, 0700); err != nil {
-		return errors.Wrap(err, fmt.Sprintf("unable to write cache file %s", filename))
+// Refresh re-fetches all members and teams from GitHub regardless of the cache TTL and updates the cache
+func (g *GH) Refresh() (RefreshResult, error) {
+	return g.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh but uses the provided context for the API calls
+func (g *GH) RefreshContext(ctx context.Context) (RefreshResult, error) {
+	g.refreshMu.Lock()
+	defer g.refreshMu.Unlock()
+
+	return g.getMembersAndTeams(ctx, true)
+}
+
+// StartAutoRefresh calls Refresh every interval in the background until ctx is cancelled or the client
+// is closed. A failed refresh is reported to the logger and the previous members and teams are kept.
+func (g *GH) StartAutoRefresh(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
 	}
-	return nil
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		// Close cancels a refresh that is in flight
+		select {
+		case <-g.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	go func() {
+		defer cancel()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := g.RefreshContext(ctx); err != nil {
+					g.logf("unable to refresh members and teams: %v", err)
+				}
+			}
+		}
+	}()
 }
 
-func getCached(filename string, v interface{}) error {
-	_, err := os.Stat(filename)
+// getMembers fetches every member sorted by login and the active member's teams. capped is true when
+// WithMaxMembers stopped the fetch before every member was listed.
+func (g *GH) getMembers(ctx context.Context) (members []Member, activeMemberTeams []string, capped bool, err error) {
+	defer g.since("members", time.Now())
+	members = []Member{}
+
+	activeMemberTeams, capped, err = g.streamMembers(ctx, func(m Member) error {
+		members = append(members, m)
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, nil, false, err
 	}
+	if g.opts.includeSelf {
+		if members, err = g.addAuthenticatedUser(ctx, members); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	ByMembers(g.memberLess()).Sort(members)
+
+	return members, activeMemberTeams, capped, nil
+}
 
-	buf, err := ioutil.ReadFile(filename)
+// addAuthenticatedUser adds the token's own user to members unless they are already there
+func (g *GH) addAuthenticatedUser(ctx context.Context, members []Member) ([]Member, error) {
+	var u *github.User
+	_, err := g.callAPI(ctx, "get_user", func(ctx context.Context) (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		u, resp, err = g.UsersService.Get(ctx, "")
+		return resp, err
+	})
 	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("unable to read cached file %s", filename))
+		return nil, g.wrapError(err, "error looking up the authenticated user")
 	}
+	for _, m := range members {
+		if strings.EqualFold(m.Login, u.GetLogin()) {
+			return members, nil
+		}
+	}
+	return append(members, g.annotate(memberFromUser(u.GetLogin(), MemberStateActive, u))), nil
+}
 
-	if err := json.Unmarshal(buf, v); err != nil {
-		return errors.Wrap(err, fmt.Sprintf("unable to unmarshal cache file: %s", filename))
+// annotate passes a freshly fetched member through the WithMemberHook hook
+func (g *GH) annotate(m Member) Member {
+	if g.opts.memberHook == nil {
+		return m
 	}
+	return g.opts.memberHook(m)
+}
 
-	return nil
+// memberLess returns the member order chosen with WithMemberSort
+func (g *GH) memberLess() ByMembers {
+	if g.opts.memberSort == MemberSortName {
+		return sortMemberNames
+	}
+	return sortMemberLogins
 }
 
-func (g *GH) getMembers() error {
-	members := []Member{}
+// streamMembers fetches every member, handing each to emit from a single goroutine as soon as it has
+// been looked up, and returns the active member's teams and whether WithMaxMembers cut the listing
+// short. Once emit fails it isn't called again, the lookups still running are cancelled and its error
+// is returned.
+func (g *GH) streamMembers(parent context.Context, emit func(Member) error) ([]string, bool, error) {
+	activeMemberTeams := []string{}
+	var emitErr error
+	capped := false
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
 
-	in := make(chan string)
+	in := make(chan Member)
 	out := make(chan Member)
+	collected := make(chan struct{})
 
-	activeMember, err := g.Whoami()
+	activeMember, err := g.WhoamiContext(ctx)
 	if err != nil {
-		return err
+		return nil, false, err
+	}
+
+	nameIDs := map[string]string{}
+	if g.opts.samlIdentities {
+		if nameIDs, err = g.getSAMLIdentities(ctx); err != nil {
+			return nil, false, err
+		}
 	}
 
 	// This process can be slow so we speed it up by doing multiple lookups at a time.
 	// Was implemented because it took about 45 seconds to get all members and teams and this
-	// took it down to about 3 seconds.
-	grp, _ := errgroup.WithContext(context.Background())
-	for i := 0; i < ghWorkers; i++ {
+	// took it down to about 3 seconds. The first worker to fail cancels grpCtx, which stops the
+	// lookups still in flight and the listing below.
+	grp, grpCtx := errgroup.WithContext(ctx)
+	for i := 0; i < g.opts.workers; i++ {
+		delay := g.workerDelay()
 		grp.Go(func() error {
-			for login := range in {
-				u, _, err := g.Client.Users.Get(context.Background(), login)
+			select {
+			case <-grpCtx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+
+			for seed := range in {
+				login := seed.Login
+				var u *github.User
+				_, err := g.callAPI(grpCtx, "get_user", func(ctx context.Context) (*github.Response, error) {
+					var resp *github.Response
+					var err error
+					u, resp, err = g.ghClient.Users.Get(ctx, login)
+					return resp, err
+				})
+				if StatusCode(err) == http.StatusNotFound {
+					// The user left or was removed after the member list was fetched
+					g.logf("member %s no longer exists, skipping", login)
+					continue
+				}
 				if err != nil {
-					return errors.Wrap(err, fmt.Sprintf("error looking up member %s", login))
+					return g.wrapError(err, fmt.Sprintf("error looking up member %s", login))
 				}
 
 				// Get memberships for the local user, we don't care about everybody's membership
 				if login == activeMember {
-					teams := []string{}
-					teams, err = g.getTeamMemberships(login)
+					activeMemberTeams, err = g.getTeamMemberships(grpCtx, login)
 					if err != nil {
 						return err
 					}
-					g.ActiveMemberTeams = teams
 				}
-				out <- Member{Login: login, Name: u.GetName()}
+				m := memberFromUser(login, seed.State, u)
+				m.SAMLNameID = nameIDs[strings.ToLower(login)]
+				out <- m
 			}
 			return nil
 		})
@@ -205,55 +610,309 @@ func (g *GH) getMembers() error {
 
 	go func() {
 		for mem := range out {
-			members = append(members, mem)
+			if emitErr == nil {
+				if emitErr = emit(g.annotate(mem)); emitErr != nil {
+					cancel()
+				}
+			}
 		}
+		close(collected)
 	}()
 
-	nextPage := 1
-	for nextPage > 0 {
-		ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
-		defer cancel()
-		mems, resp, err := g.Client.Organizations.ListMembers(ctx, g.Org, &github.ListMembersOptions{ListOptions: github.ListOptions{Page: nextPage}})
-		if err != nil {
-			return errors.Wrap(err, "unable to get members from GitHub")
+	// send hands a member to the workers, giving up once they have stopped
+	send := func(m Member) bool {
+		select {
+		case in <- m:
+			return true
+		case <-grpCtx.Done():
+			return false
 		}
+	}
 
-		for _, m := range mems {
-			in <- m.GetLogin()
+	listErr := func() error {
+		sent := 0
+		nextPage := 1
+		for nextPage > 0 {
+			var mems []*github.User
+			resp, err := g.listPage(grpCtx, "list_members", func(pageCtx context.Context) (*github.Response, error) {
+				var resp *github.Response
+				var err error
+				mems, resp, err = g.ghClient.Organizations.ListMembers(pageCtx, g.Org, &github.ListMembersOptions{
+					PublicOnly:  g.opts.memberFilter.PublicOnly,
+					Filter:      g.opts.memberFilter.filter(),
+					Role:        g.opts.memberFilter.Role,
+					ListOptions: github.ListOptions{Page: nextPage, PerPage: g.opts.perPage},
+				})
+				return resp, err
+			})
+			if err != nil {
+				return g.wrapError(err, "unable to get members from GitHub")
+			}
+
+			for _, m := range mems {
+				if g.opts.maxMembers > 0 && sent == g.opts.maxMembers {
+					capped = true
+					break
+				}
+				if !send(Member{Login: m.GetLogin(), State: MemberStateActive}) {
+					return nil
+				}
+				sent++
+			}
+
+			nextPage = resp.NextPage
+			if g.opts.maxMembers > 0 && sent == g.opts.maxMembers {
+				capped = capped || nextPage > 0
+				nextPage = 0
+			}
 		}
 
-		nextPage = resp.NextPage
-	}
+		if g.opts.pendingMembers && (g.opts.maxMembers == 0 || sent < g.opts.maxMembers) {
+			return g.getPendingMembers(grpCtx, send)
+		}
+		return nil
+	}()
 
 	close(in)
-	if err := grp.Wait(); err != nil {
-		return errors.Wrap(err, "error looking up members")
-	}
+	err = grp.Wait()
 	close(out)
-	ByMembers(sortMemberLogins).Sort(members)
+	<-collected
+	// Lookups cancelled because emit failed report that rather than the cancellation
+	if emitErr != nil {
+		return nil, false, emitErr
+	}
+	if err != nil {
+		return nil, false, errors.Wrap(err, "error looking up members")
+	}
+	if listErr != nil {
+		return nil, false, listErr
+	}
+	// The listing stops early without an error of its own when ctx is cancelled between pages
+	if err := parent.Err(); err != nil {
+		return nil, false, errors.Wrap(err, "error looking up members")
+	}
+
+	return activeMemberTeams, capped, nil
+}
+
+// workerDelay is how long a lookup worker waits before starting. Starting every worker at once can trip
+// GitHub's secondary rate limits, so each waits a little.
+func (g *GH) workerDelay() time.Duration {
+	if g.opts.workerJitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(g.opts.workerJitter)))
+}
+
+// memberFromUser builds a member from the user GitHub returned for login
+func memberFromUser(login, state string, u *github.User) Member {
+	m := Member{
+		ID:        u.GetID(),
+		Login:     login,
+		Name:      u.GetName(),
+		AvatarURL: u.GetAvatarURL(),
+		State:     state,
+		Company:   strings.TrimSpace(u.GetCompany()),
+		Location:  strings.TrimSpace(u.GetLocation()),
+		Email:     strings.TrimSpace(u.GetEmail()),
+	}
+	if u.UpdatedAt != nil {
+		updated := u.UpdatedAt.Time
+		m.LastActive = &updated
+	}
+	return m
+}
+
+// ErrNotMember is the cause of AddMemberLive's error for a user who isn't in the organization
+var ErrNotMember = errors.New("user is not a member of the organization")
+
+// ErrFilteredMember is the cause of AddMemberLive's error for a member WithMemberFilter leaves out
+var ErrFilteredMember = errors.New("member doesn't match the member filter")
+
+// AddMemberLive looks up a single user on GitHub and adds them to the members, replacing any cached
+// entry for the same login, then saves the members cache. It is a cheap way to pick up someone who
+// just joined without a full refresh. A user who isn't a member of the organization is rejected with
+// ErrNotMember, so the lookup can't make an outsider a recipient, and one WithMemberFilter would have
+// left out with ErrFilteredMember.
+func (g *GH) AddMemberLive(login string) (Member, error) {
+	return g.AddMemberLiveContext(context.Background(), login)
+}
+
+// AddMemberLiveContext is like AddMemberLive but uses the provided context for the API call
+func (g *GH) AddMemberLiveContext(ctx context.Context, login string) (Member, error) {
+	var u *github.User
+	_, err := g.callAPI(ctx, "get_user", func(ctx context.Context) (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		u, resp, err = g.UsersService.Get(ctx, login)
+		return resp, err
+	})
+	if err != nil {
+		return Member{}, g.wrapError(err, fmt.Sprintf("error looking up member %s", login))
+	}
+	// Use GitHub's spelling of the login rather than however it was typed
+	login = u.GetLogin()
+	member, err := g.IsMemberLiveContext(ctx, login)
+	if err != nil {
+		return Member{}, err
+	}
+	if !member {
+		return Member{}, errors.Wrap(ErrNotMember, login)
+	}
+	// The filtered cache must only hold the members a full fetch would have listed
+	matches, err := g.matchesMemberFilter(ctx, login)
+	if err != nil {
+		return Member{}, err
+	}
+	if !matches {
+		return Member{}, errors.Wrap(ErrFilteredMember, login)
+	}
+	m := g.annotate(memberFromUser(login, MemberStateActive, u))
+
+	g.refreshMu.Lock()
+	defer g.refreshMu.Unlock()
+
+	// Copy the members so slices already handed out to callers aren't changed underneath them
+	g.mu.Lock()
+	members := make([]Member, 0, len(g.Members)+1)
+	for _, existing := range g.Members {
+		if !strings.EqualFold(existing.Login, m.Login) {
+			members = append(members, existing)
+		}
+	}
+	less := g.memberLess()
+	i := sort.Search(len(members), func(i int) bool { return !less(&members[i], &m) })
+	members = append(members, Member{})
+	copy(members[i+1:], members[i:])
+	members[i] = m
 	g.Members = members
+	g.mu.Unlock()
 
+	// The same results saveInfo won't cache aren't cached here either
+	if !g.cacheable(g.currentInfo()) {
+		return m, nil
+	}
+	return m, g.withCacheLock(func() error {
+		if g.opts.singleFileCache {
+			return g.saveDirectory(g.currentInfo(), g.cacheFile(directoryCacheFile))
+		}
+		if err := g.saveCache(g.cacheFile(membersCacheFile), members); err != nil {
+			return errors.Wrap(err, "unable to save members file")
+		}
+		return nil
+	})
+}
+
+// getPendingMembers sends members who have been invited to the organization but haven't joined yet,
+// stopping when send returns false. Invitations sent to an email address without a GitHub account are
+// skipped.
+func (g *GH) getPendingMembers(ctx context.Context, send func(Member) bool) error {
+	nextPage := 1
+	for nextPage > 0 {
+		var invitations []*github.Invitation
+		resp, err := g.listPage(ctx, "list_pending_invitations", func(pageCtx context.Context) (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			invitations, resp, err = g.ghClient.Organizations.ListPendingOrgInvitations(pageCtx, g.Org, &github.ListOptions{Page: nextPage, PerPage: g.opts.perPage})
+			return resp, err
+		})
+		if err != nil {
+			return g.wrapError(err, "unable to get pending members from GitHub")
+		}
+
+		for _, i := range invitations {
+			if i.GetLogin() != "" && !send(Member{Login: i.GetLogin(), State: MemberStatePending}) {
+				return nil
+			}
+		}
+
+		nextPage = resp.NextPage
+	}
 	return nil
 }
 
-func (g *GH) getTeams() error {
+// getTeams fetches every team sorted by name. capped is true when WithMaxTeams stopped the fetch before
+// every team was listed.
+func (g *GH) getTeams(ctx context.Context) ([]Team, bool, error) {
+	defer g.since("teams", time.Now())
 	teams := []Team{}
+	capped := false
 
 	in := make(chan *github.Team)
 	out := make(chan Team)
+	collected := make(chan struct{})
+	// membersErr is the first of membersFailed teams whose members couldn't be looked up. teamErrMu
+	// guards both and also keeps the warnings from being logged concurrently.
+	var membersErr error
+	membersFailed := 0
+	var teamErrMu sync.Mutex
 
 	// This process can be slow so we speed it up by doing multiple lookups at a time.
 	// Was implemented because it took about 45 seconds to get all members and teams and this
-	// took it down to about 3 seconds.
-	grp, _ := errgroup.WithContext(context.Background())
-	for i := 0; i < ghWorkers; i++ {
+	// took it down to about 3 seconds. The first worker to fail cancels grpCtx, which stops the
+	// lookups still in flight and the listing below.
+	grp, grpCtx := errgroup.WithContext(ctx)
+	for i := 0; i < g.opts.workers; i++ {
 		grp.Go(func() error {
 			for team := range in {
-				mems, err := g.getTeamMembers(team.GetID())
+				t := Team{ID: team.GetID(), Name: team.GetName(), Slug: team.GetSlug(), ParentID: team.GetParent().GetID(), Secret: team.GetPrivacy() == "secret"}
+				// tolerate lets a lookup for one team fail without failing the fetch, unless WithStrictTeams
+				// is set or the fetch as a whole is being cancelled
+				tolerate := func(err error, what string) error {
+					err = g.wrapError(err, fmt.Sprintf("error looking up %s of team %s", what, team.GetName()))
+					if g.opts.strictTeams || grpCtx.Err() != nil {
+						return err
+					}
+					t.Incomplete = true
+					teamErrMu.Lock()
+					g.logf("%v, continuing without them", err)
+					teamErrMu.Unlock()
+					return nil
+				}
+
+				mems, err := g.getTeamMembers(grpCtx, team.GetID(), "all")
 				if err != nil {
-					return errors.Wrap(err, fmt.Sprintf("error looking up members of team %s", team.GetName()))
+					if err := tolerate(err, "members"); err != nil {
+						return err
+					}
+					teamErrMu.Lock()
+					membersFailed++
+					if membersErr == nil {
+						membersErr = err
+					}
+					teamErrMu.Unlock()
+					// A team without its members has nothing else worth fetching
+					out <- t
+					continue
 				}
-				out <- Team{Name: team.GetName(), Members: mems}
+				t.Members = mems
+				t.Empty = len(mems) == 0
+				if g.opts.teamRepos {
+					repos, err := g.getTeamRepos(grpCtx, team.GetID())
+					if err != nil {
+						if err := tolerate(err, "repositories"); err != nil {
+							return err
+						}
+					}
+					t.Repos = repos
+				}
+				if g.opts.teamMaintainers {
+					maintainers, err := g.getTeamMembers(grpCtx, team.GetID(), "maintainer")
+					if err != nil {
+						if err := tolerate(err, "maintainers"); err != nil {
+							return err
+						}
+					}
+					t.Maintainers = maintainers
+				}
+				if g.opts.teamTimes {
+					if err := g.getTeamTimes(grpCtx, &t); err != nil {
+						if err := tolerate(err, "details"); err != nil {
+							return err
+						}
+					}
+				}
+				out <- t
 
 			}
 			return nil
@@ -261,48 +920,96 @@ func (g *GH) getTeams() error {
 	}
 
 	go func() {
-		for {
-			team, ok := <-out
-			if ok {
-				teams = append(teams, team)
-			} else {
-				break
-			}
+		for team := range out {
+			teams = append(teams, team)
 		}
+		close(collected)
 	}()
 
-	nextPage := 1
-	for nextPage > 0 {
-		ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
-		defer cancel()
-		ts, resp, err := g.Client.Teams.ListTeams(ctx, g.Org, &github.ListOptions{Page: nextPage})
-		if err != nil {
-			return errors.Wrap(err, "unable to get teams from GitHub")
-		}
+	listErr := func() error {
+		sent := 0
+		nextPage := 1
+		for nextPage > 0 {
+			var ts []*github.Team
+			resp, err := g.listPage(grpCtx, "list_teams", func(pageCtx context.Context) (*github.Response, error) {
+				var resp *github.Response
+				var err error
+				ts, resp, err = g.ghClient.Teams.ListTeams(pageCtx, g.Org, &github.ListOptions{Page: nextPage, PerPage: g.opts.perPage})
+				return resp, err
+			})
+			if err != nil {
+				return g.wrapError(err, "unable to get teams from GitHub")
+			}
 
-		for _, t := range ts {
-			in <- t
+			for _, t := range ts {
+				if !g.keepTeam(t.GetName(), t.GetSlug()) {
+					continue
+				}
+				if g.opts.maxTeams > 0 && sent == g.opts.maxTeams {
+					capped = true
+					break
+				}
+				select {
+				case in <- t:
+				case <-grpCtx.Done():
+					return nil
+				}
+				sent++
+			}
+
+			nextPage = resp.NextPage
+			if g.opts.maxTeams > 0 && sent == g.opts.maxTeams {
+				capped = capped || nextPage > 0
+				nextPage = 0
+			}
 		}
+		return nil
+	}()
 
-		nextPage = resp.NextPage
-	}
 	close(in)
-	if err := grp.Wait(); err != nil {
-		return errors.Wrap(err, "unable to lookup teams")
-	}
+	err := grp.Wait()
 	close(out)
+	<-collected
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to lookup teams")
+	}
+	if listErr != nil {
+		return nil, false, listErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, false, errors.Wrap(err, "unable to lookup teams")
+	}
+	if len(teams) > 0 && membersFailed == len(teams) {
+		// Something is wrong beyond a few teams the token can't see
+		return nil, false, errors.Wrap(membersErr, "unable to lookup the members of any of the teams")
+	}
 	ByTeams(sortTeamNames).Sort(teams)
-	g.Info.Teams = teams
 
-	return nil
+	return teams, capped, nil
 }
 
-func (g *GH) getTeamMembers(id int64) ([]string, error) {
+// keepTeam reports whether a team passes WithTeamFilter
+func (g *GH) keepTeam(name, slug string) bool {
+	if g.opts.teamFilter == "" {
+		return true
+	}
+	prefix := strings.ToLower(g.opts.teamFilter)
+	return strings.HasPrefix(strings.ToLower(slug), prefix) || strings.HasPrefix(strings.ToLower(name), prefix)
+}
+
+// getTeamMembers lists the logins in a team with the given role, which is "all", "member" or "maintainer"
+func (g *GH) getTeamMembers(ctx context.Context, id int64, role string) ([]string, error) {
 	members := []string{}
 	nextPage := 1
 
 	for nextPage > 0 {
-		users, resp, err := g.Client.Teams.ListTeamMembers(context.Background(), id, &github.TeamListTeamMembersOptions{Role: "all", ListOptions: github.ListOptions{Page: nextPage}})
+		var users []*github.User
+		resp, err := g.callAPI(ctx, "list_team_members", func(ctx context.Context) (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			users, resp, err = g.ghClient.Teams.ListTeamMembers(ctx, id, &github.TeamListTeamMembersOptions{Role: role, ListOptions: github.ListOptions{Page: nextPage, PerPage: g.opts.perPage}})
+			return resp, err
+		})
 		if err != nil {
 			return members, err
 		}
@@ -315,91 +1022,663 @@ func (g *GH) getTeamMembers(id int64) ([]string, error) {
 	return members, nil
 }
 
-// GetMatches will search for a given value as part of a username or team name and return a set of
-// available options for the user.
-func (g *GH) GetMatches(lookup string) Matches {
-	matches := Matches{}
+func (g *GH) getTeamRepos(ctx context.Context, id int64) ([]string, error) {
+	repos := []string{}
+	nextPage := 1
 
-	if lookup == "*" {
-		matches.Members = g.Members
-		matches.Teams = g.Info.Teams
-		return matches
+	for nextPage > 0 {
+		var rs []*github.Repository
+		resp, err := g.callAPI(ctx, "list_team_repos", func(ctx context.Context) (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			rs, resp, err = g.ghClient.Teams.ListTeamRepos(ctx, id, &github.ListOptions{Page: nextPage, PerPage: g.opts.perPage})
+			return resp, err
+		})
+		if err != nil {
+			return repos, err
+		}
+		for _, r := range rs {
+			repos = append(repos, r.GetName())
+		}
+		nextPage = resp.NextPage
 	}
 
-	for _, m := range g.Members {
-		if strings.Contains(strings.ToLower(m.Login), strings.ToLower(lookup)) || strings.Contains(strings.ToLower(m.Name), strings.ToLower(lookup)) {
-			matches.Members = append(matches.Members, m)
+	return repos, nil
+}
+
+// teamTimes is the part of a full team response go-github doesn't decode
+type teamTimes struct {
+	CreatedAt github.Timestamp `json:"created_at"`
+	UpdatedAt github.Timestamp `json:"updated_at"`
+}
+
+// getTeamTimes fills in when the team was created and last updated from the single team endpoint
+func (g *GH) getTeamTimes(ctx context.Context, t *Team) error {
+	req, err := g.ghClient.NewRequest("GET", fmt.Sprintf("teams/%d", t.ID), nil)
+	if err != nil {
+		return err
+	}
+
+	var times teamTimes
+	_, err = g.callAPI(ctx, "get_team", func(ctx context.Context) (*github.Response, error) {
+		return g.ghClient.Do(ctx, req, &times)
+	})
+	if err != nil {
+		return err
+	}
+	t.CreatedAt = &times.CreatedAt.Time
+	t.UpdatedAt = &times.UpdatedAt.Time
+	return nil
+}
+
+// GetTeamsByRepo returns the teams with access to the repository, sorted by name. Teams only know their
+// repositories when the client was created with WithTeamRepos. The repository may be given as
+// "owner/repo" or just "repo".
+func (g *GH) GetTeamsByRepo(repo string) []Team {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if i := strings.Index(repo, "/"); i >= 0 {
+		if !strings.EqualFold(repo[:i], g.Org) {
+			return []Team{}
 		}
+		repo = repo[i+1:]
 	}
 
+	teams := []Team{}
 	for _, t := range g.Info.Teams {
-		if strings.Contains(strings.ToLower(t.Name), strings.ToLower(lookup)) {
-			matches.Teams = append(matches.Teams, t)
+		for _, r := range t.Repos {
+			if strings.EqualFold(r, repo) {
+				teams = append(teams, t)
+				break
+			}
+		}
+	}
+	ByTeams(sortTeamNames).Sort(teams)
+	return teams
+}
+
+// GetRepoCollaborators returns the collaborators of a repository as members. The repository may be given
+// as "owner/repo" or just "repo" for one owned by the organization.
+func (g *GH) GetRepoCollaborators(repo string) ([]Member, error) {
+	return g.GetRepoCollaboratorsContext(context.Background(), repo)
+}
+
+// GetRepoCollaboratorsContext is like GetRepoCollaborators but uses the provided context for the API calls
+func (g *GH) GetRepoCollaboratorsContext(ctx context.Context, repo string) ([]Member, error) {
+	owner, name := g.Org, repo
+	if i := strings.Index(repo, "/"); i >= 0 {
+		owner, name = repo[:i], repo[i+1:]
+	}
+
+	g.mu.RLock()
+	known := make(map[string]Member, len(g.Members))
+	for _, m := range g.Members {
+		known[strings.ToLower(m.Login)] = m
+	}
+	g.mu.RUnlock()
+
+	members := []Member{}
+	nextPage := 1
+	for nextPage > 0 {
+		var users []*github.User
+		resp, err := g.callAPI(ctx, "list_collaborators", func(ctx context.Context) (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			users, resp, err = g.ghClient.Repositories.ListCollaborators(ctx, owner, name, &github.ListCollaboratorsOptions{ListOptions: github.ListOptions{Page: nextPage, PerPage: g.opts.perPage}})
+			return resp, err
+		})
+		if err != nil {
+			return []Member{}, g.wrapError(err, fmt.Sprintf("unable to get collaborators of %s/%s", owner, name))
+		}
+
+		for _, u := range users {
+			// The list response doesn't include names so prefer what we already know about the member
+			if m, ok := known[strings.ToLower(u.GetLogin())]; ok {
+				members = append(members, m)
+				continue
+			}
+			members = append(members, Member{Login: u.GetLogin(), Name: u.GetName(), AvatarURL: u.GetAvatarURL()})
+		}
+		nextPage = resp.NextPage
+	}
+
+	ByMembers(sortMemberLogins).Sort(members)
+	return members, nil
+}
+
+// RefreshMembers re-fetches the members and the active member's teams and updates their caches, leaving
+// the teams as they are. It skips the per-team member lookups when only the roster has changed.
+func (g *GH) RefreshMembers() error {
+	return g.RefreshMembersContext(context.Background())
+}
+
+// RefreshMembersContext is like RefreshMembers but uses the provided context for the API calls
+func (g *GH) RefreshMembersContext(ctx context.Context) error {
+	g.refreshMu.Lock()
+	defer g.refreshMu.Unlock()
+
+	if g.opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.opts.timeout)
+		defer cancel()
+	}
+
+	members, activeMemberTeams, _, err := g.getMembers(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to get members from GitHub")
+	}
+
+	g.mu.Lock()
+	// The teams weren't refreshed, so point them at the new logins of any renamed members
+	g.Info.Teams = g.trackRenames(g.Members, members, g.Info.Teams)
+	g.indexTeams()
+	g.Members = members
+	g.ActiveMemberTeams = activeMemberTeams
+	teams := g.Info.Teams
+	g.mu.Unlock()
+
+	// The same results saveInfo won't cache aren't cached here either
+	if !g.cacheable(g.currentInfo()) {
+		return nil
+	}
+	if g.opts.cacheStore == nil {
+		if err := g.createCacheDir(); err != nil {
+			return err
+		}
+	}
+	return g.withCacheLock(func() error {
+		if g.opts.singleFileCache {
+			return g.saveDirectory(g.currentInfo(), g.cacheFile(directoryCacheFile))
+		}
+		if err := g.saveCache(g.cacheFile(membersCacheFile), members); err != nil {
+			return errors.Wrap(err, "unable to save members file")
+		}
+		if err := g.saveCache(g.cacheFile(activeMembershipsCacheFile), g.cacheableTeamNames(activeMemberTeams, teams)); err != nil {
+			return errors.Wrap(err, "unable to save active memberships file")
+		}
+		return nil
+	})
+}
+
+// RefreshTeam re-fetches the members of a single team and updates the cache, leaving everything else as is
+func (g *GH) RefreshTeam(name string) error {
+	return g.RefreshTeamContext(context.Background(), name)
+}
+
+// RefreshTeamContext is like RefreshTeam but uses the provided context for the API calls
+func (g *GH) RefreshTeamContext(ctx context.Context, name string) error {
+	g.refreshMu.Lock()
+	defer g.refreshMu.Unlock()
+
+	g.mu.RLock()
+	teams := g.findTeams(name)
+	g.mu.RUnlock()
+
+	switch {
+	case len(teams) == 0:
+		return fmt.Errorf("team '%s' does not exist in directory", name)
+	case len(teams) > 1:
+		return fmt.Errorf("team name '%s' is used by %d teams, use the team's slug instead", name, len(teams))
+	}
+	team := teams[0]
+	if team.ID == 0 {
+		return fmt.Errorf("team '%s' was cached without an ID, update the whole cache instead", team.Name)
+	}
+
+	mems, err := g.getTeamMembers(ctx, team.ID, "all")
+	if err != nil {
+		return g.wrapError(err, fmt.Sprintf("error looking up members of team %s", team.Name))
+	}
+	maintainers := team.Maintainers
+	if g.opts.teamMaintainers {
+		maintainers, err = g.getTeamMembers(ctx, team.ID, "maintainer")
+		if err != nil {
+			return g.wrapError(err, fmt.Sprintf("error looking up maintainers of team %s", team.Name))
+		}
+	}
+
+	// Copy the teams so slices already handed out to callers aren't changed underneath them
+	g.mu.Lock()
+	teams = make([]Team, len(g.Info.Teams))
+	copy(teams, g.Info.Teams)
+	for i := range teams {
+		if teams[i].ID == team.ID {
+			teams[i].Members = mems
+			teams[i].Maintainers = maintainers
+			teams[i].Empty = len(mems) == 0
+			teams[i].Incomplete = false
+		}
+	}
+	g.Info.Teams = teams
+	g.indexTeams()
+	g.mu.Unlock()
+
+	// A filtered or capped team list must not replace the full one in the cache
+	if !g.cacheable(g.currentInfo()) {
+		return nil
+	}
+	return g.withCacheLock(func() error {
+		if g.opts.singleFileCache {
+			return g.saveDirectory(g.currentInfo(), g.cacheFile(directoryCacheFile))
+		}
+		if err := g.saveCache(g.cacheFile(teamsCacheFile), g.cacheableTeams(teams)); err != nil {
+			return errors.Wrap(err, "unable to save teams file")
+		}
+		return nil
+	})
+}
+
+// GetMemberByID returns the member with the given numeric GitHub ID, whatever their login is now
+func (g *GH) GetMemberByID(id int64) (Member, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if id == 0 {
+		return Member{}, false
+	}
+	for _, m := range g.Members {
+		if m.ID == id {
+			return m, true
 		}
 	}
-	return matches
+	return Member{}, false
 }
 
 // IsMember will check an organization for a specific user
 func (g *GH) IsMember(lookup string) (string, bool) {
-	for _, u := range g.Members {
-		if strings.ToLower(lookup) == strings.ToLower(u.Login) {
-			return u.Login, true
+	return g.IsMemberWithOptions(lookup, MatchOptions{})
+}
+
+// IsMemberWithOptions is like IsMember but compares logins according to the match options
+func (g *GH) IsMemberWithOptions(lookup string, mo MatchOptions) (string, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	for _, m := range g.Members {
+		if mo.equal(lookup, m.Login) {
+			return m.Login, true
 		}
 	}
 	return "", false
 }
 
-// IsTeam will check an organization for a specific team
-func (g *GH) IsTeam(lookup string) (string, bool) {
-	for _, t := range g.Info.Teams {
-		if strings.ToLower(lookup) == strings.ToLower(t.Name) {
-			return t.Name, true
+// IsMemberLive asks GitHub whether the user is a member of the organization instead of trusting the
+// cache. Use it for authorization checks where a stale cache isn't acceptable.
+func (g *GH) IsMemberLive(login string) (bool, error) {
+	return g.IsMemberLiveContext(context.Background(), login)
+}
+
+// IsMemberLiveContext is like IsMemberLive but uses the provided context for the API call
+func (g *GH) IsMemberLiveContext(ctx context.Context, login string) (bool, error) {
+	var ok bool
+	_, err := g.callAPI(ctx, "is_member", func(ctx context.Context) (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		ok, resp, err = g.ghClient.Organizations.IsMember(ctx, g.Org, login)
+		return resp, err
+	})
+	if err != nil {
+		return false, g.wrapError(err, fmt.Sprintf("unable to check membership of %s", login))
+	}
+	return ok, nil
+}
+
+// matchesMemberFilter reports whether the organization member login would be listed with the
+// WithMemberFilter filter. GitHub can't check two-factor authentication for a single user, so that filter
+// lists the members without it.
+func (g *GH) matchesMemberFilter(ctx context.Context, login string) (bool, error) {
+	f := g.opts.memberFilter
+	if f.Role != "" && f.Role != "all" {
+		var membership *github.Membership
+		_, err := g.callAPI(ctx, "get_membership", func(ctx context.Context) (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			membership, resp, err = g.ghClient.Organizations.GetOrgMembership(ctx, login, g.Org)
+			return resp, err
+		})
+		if err != nil {
+			return false, g.wrapError(err, fmt.Sprintf("unable to check the role of %s", login))
+		}
+		if membership.GetRole() != f.Role {
+			return false, nil
+		}
+	}
+	if f.PublicOnly {
+		var public bool
+		_, err := g.callAPI(ctx, "is_public_member", func(ctx context.Context) (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			public, resp, err = g.ghClient.Organizations.IsPublicMember(ctx, g.Org, login)
+			return resp, err
+		})
+		if err != nil {
+			return false, g.wrapError(err, fmt.Sprintf("unable to check public membership of %s", login))
+		}
+		if !public {
+			return false, nil
 		}
 	}
+	if f.TwoFactorDisabled {
+		nextPage := 1
+		for nextPage > 0 {
+			var mems []*github.User
+			resp, err := g.listPage(ctx, "list_members", func(pageCtx context.Context) (*github.Response, error) {
+				var resp *github.Response
+				var err error
+				mems, resp, err = g.ghClient.Organizations.ListMembers(pageCtx, g.Org, &github.ListMembersOptions{
+					Filter:      f.filter(),
+					ListOptions: github.ListOptions{Page: nextPage, PerPage: g.opts.perPage},
+				})
+				return resp, err
+			})
+			if err != nil {
+				return false, g.wrapError(err, "unable to get members from GitHub")
+			}
+			for _, m := range mems {
+				if strings.EqualFold(m.GetLogin(), login) {
+					return true, nil
+				}
+			}
+			nextPage = resp.NextPage
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// IsTeam will check an organization for a specific team by slug or name, optionally qualified as
+// "org/team". A name shared by several teams isn't considered a match, use GetTeam to find out which
+// teams share it.
+func (g *GH) IsTeam(lookup string) (string, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if t, ok := g.findTeam(lookup); ok {
+		return t.Name, true
+	}
 	return "", false
 }
 
-// GetTeamMembers returns a list of members for the provided team name
+// GetTeamMembers returns a list of members for the provided team slug or name, optionally qualified as
+// "org/team". Nothing is returned when the name is shared by several teams rather than picking one of
+// them.
 func (g *GH) GetTeamMembers(name string) []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if t, ok := g.findTeam(name); ok {
+		return t.Members
+	}
+	return []string{}
+}
+
+// GetTeamMaintainers returns the logins of the members who maintain a team. It is empty for unknown
+// teams and unless the client was created with WithTeamMaintainers.
+func (g *GH) GetTeamMaintainers(name string) []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if t, ok := g.findTeam(name); ok && t.Maintainers != nil {
+		return t.Maintainers
+	}
+	return []string{}
+}
+
+// GetChildTeams returns the teams nested directly under the team with the given slug or name, sorted by
+// name. Teams cached before parents were recorded have no children until the cache is refreshed.
+func (g *GH) GetChildTeams(parentName string) []Team {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	children := []Team{}
+	parent, ok := g.findTeam(parentName)
+	if !ok || parent.ID == 0 {
+		return children
+	}
 	for _, t := range g.Info.Teams {
-		if name == t.Name {
-			return t.Members
+		if t.ParentID == parent.ID {
+			children = append(children, t)
 		}
 	}
-	return []string{}
+	ByTeams(sortTeamNames).Sort(children)
+	return children
 }
 
-// Whoami returns the login name of the currently authenitcated user
+// GetTeamsByName returns every team with the given slug or name so callers can disambiguate teams
+// that share a name
+func (g *GH) GetTeamsByName(name string) []Team {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.findTeams(name)
+}
+
+// Whoami returns the login name of the currently authenitcated user. The call is limited to the
+// WithTimeout budget or, without it, the couple of seconds a page of results gets.
 func (g *GH) Whoami() (string, error) {
-	user, _, err := g.UsersService.Get(context.Background(), "")
+	timeout := contextTimeout
+	if g.opts.timeout > 0 {
+		timeout = g.opts.timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return g.WhoamiContext(ctx)
+}
+
+// WhoamiContext is like Whoami but uses the provided context for the API call, with no limit of its own
+func (g *GH) WhoamiContext(ctx context.Context) (string, error) {
+	var user *github.User
+	_, err := g.callAPI(ctx, "get_user", func(ctx context.Context) (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		user, resp, err = g.UsersService.Get(ctx, "")
+		return resp, err
+	})
+	if err != nil && ctx.Err() != nil {
+		return "", errors.Wrap(ctx.Err(), "gave up getting authenticated user's login")
+	}
 	if err != nil {
 		return "", errors.Wrap(err, "unable to get authenticated user's login")
 	}
-	return *user.Login, nil
+	return user.GetLogin(), nil
+}
+
+// MyMembership returns the authenticated user's membership in the organization, including its state
+// (active or pending) and role (admin or member)
+func (g *GH) MyMembership() (*github.Membership, error) {
+	return g.MyMembershipContext(context.Background())
+}
+
+// MyMembershipContext is like MyMembership but uses the provided context for the API call
+func (g *GH) MyMembershipContext(ctx context.Context) (*github.Membership, error) {
+	var membership *github.Membership
+	_, err := g.callAPI(ctx, "get_membership", func(ctx context.Context) (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		membership, resp, err = g.ghClient.Organizations.GetOrgMembership(ctx, "", g.Org)
+		return resp, err
+	})
+	if err != nil {
+		return nil, g.wrapError(err, fmt.Sprintf("unable to get authenticated user's membership in %s", g.Org))
+	}
+	return membership, nil
+}
+
+// GetMemberTeams returns the names of the teams the given member belongs to
+func (g *GH) GetMemberTeams(login string) []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	teams, ok := g.memberTeams[strings.ToLower(login)]
+	if !ok {
+		return []string{}
+	}
+	return teams
+}
+
+// MembersWithoutTeams returns the members who aren't on any team, in the order of the members list
+func (g *GH) MembersWithoutTeams() []Member {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	members := []Member{}
+	for _, m := range g.Members {
+		if len(g.memberTeams[strings.ToLower(m.Login)]) == 0 {
+			members = append(members, m)
+		}
+	}
+	return members
+}
+
+// indexTeams rebuilds the reverse index from member logins to the teams they're on
+func (g *GH) indexTeams() {
+	index := make(map[string][]string)
+	for _, t := range g.Info.Teams {
+		for _, m := range t.Members {
+			login := strings.ToLower(m)
+			index[login] = append(index[login], t.Name)
+		}
+	}
+	g.memberTeams = index
 }
 
 // GetMembers returns the list of members
 func (g *GH) GetMembers() []Member {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
 	return g.Members
 }
 
+// MemberCount returns the number of members
+func (g *GH) MemberCount() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return len(g.Members)
+}
+
+// TeamCount returns the number of teams
+func (g *GH) TeamCount() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return len(g.Info.Teams)
+}
+
+// MemberLogins returns the sorted logins of all members, for callers that don't need the rest of Member
+func (g *GH) MemberLogins() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	logins := make([]string, 0, len(g.Members))
+	for _, m := range g.Members {
+		logins = append(logins, m.Login)
+	}
+	sort.Strings(logins)
+	return logins
+}
+
 // GetTeams returns the list of teams
 func (g *GH) GetTeams() []Team {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
 	return g.Info.Teams
 }
 
+// TeamsBySize returns the teams with the most members first, and teams of the same size by name
+func (g *GH) TeamsBySize() []Team {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	teams := append([]Team{}, g.Info.Teams...)
+	ByTeams(sortTeamSizes).Sort(teams)
+	return teams
+}
+
+// ActiveSince returns the members whose LastActive is at or after t, to leave out dormant accounts.
+// LastActive only tracks profile updates so this is approximate. Members without it are included
+// since there is nothing to judge them by.
+func (g *GH) ActiveSince(t time.Time) []Member {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	members := []Member{}
+	for _, m := range g.Members {
+		if m.LastActive == nil || !m.LastActive.Before(t) {
+			members = append(members, m)
+		}
+	}
+	return members
+}
+
+// GetMembersByState returns the members with the given membership state. Members cached before the
+// state was recorded are considered active.
+func (g *GH) GetMembersByState(state string) []Member {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	members := []Member{}
+	for _, m := range g.Members {
+		s := m.State
+		if s == "" {
+			s = MemberStateActive
+		}
+		if s == state {
+			members = append(members, m)
+		}
+	}
+	return members
+}
+
+// GetMembersByEmailDomain returns the members whose public email is at the domain or one of its
+// subdomains, ignoring case. The domain may be given with or without a leading "@". Members without a
+// public email are never included.
+func (g *GH) GetMembersByEmailDomain(domain string) []Member {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+	members := []Member{}
+	if domain == "" {
+		return members
+	}
+	for _, m := range g.Members {
+		i := strings.LastIndex(m.Email, "@")
+		if i < 0 {
+			continue
+		}
+		host := strings.ToLower(m.Email[i+1:])
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			members = append(members, m)
+		}
+	}
+	return members
+}
+
 // GetActiveMemberTeams returns a slice of team names
 func (g *GH) GetActiveMemberTeams() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
 	return g.ActiveMemberTeams
 }
 
-func (g *GH) getTeamMemberships(member string) ([]string, error) {
+func (g *GH) getTeamMemberships(ctx context.Context, member string) ([]string, error) {
 	teamNames := []string{}
 
-	opts := &github.ListOptions{Page: 1}
+	opts := &github.ListOptions{Page: 1, PerPage: g.opts.perPage}
 	for {
-		teams, resp, err := g.Client.Teams.ListUserTeams(context.Background(), &github.ListOptions{})
+		var teams []*github.Team
+		resp, err := g.callAPI(ctx, "list_user_teams", func(ctx context.Context) (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			teams, resp, err = g.ghClient.Teams.ListUserTeams(ctx, opts)
+			return resp, err
+		})
 		if err != nil {
 			return []string{}, err
 		}
@@ -2,89 +2,22 @@ package directory
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-github/github"
 	"github.com/pkg/errors"
 )
 
-func TestGetMatches(t *testing.T) {
-	testGHState := &GH{}
-	testGHState.Members = []Member{Member{Login: "test1", Name: "Test 1"}, Member{Login: "test2", Name: ""}}
-	testGHState.Info.Teams = []Team{Team{Name: "team1", Members: []string{"test1", "test2"}}, Team{Name: "team2", Members: []string{}}}
-
-	cases := map[string]struct {
-		State    *GH
-		Lookup   string
-		Expected Matches
-	}{
-		"NoLookupTest": {
-			State:  testGHState,
-			Lookup: "",
-			Expected: Matches{
-				Members: []Member{Member{Login: "test1", Name: "Test 1"}, Member{Login: "test2", Name: ""}},
-				Teams:   []Team{Team{Name: "team1", Members: []string{"test1", "test2"}}, Team{Name: "team2", Members: []string{}}},
-			},
-		},
-		"StarLookupTest": {
-			State:  testGHState,
-			Lookup: "*",
-			Expected: Matches{
-				Members: []Member{Member{Login: "test1", Name: "Test 1"}, Member{Login: "test2", Name: ""}},
-				Teams:   []Team{Team{Name: "team1", Members: []string{"test1", "test2"}}, Team{Name: "team2", Members: []string{}}},
-			},
-		},
-		"LookupUserLoginTest": {
-			State:  testGHState,
-			Lookup: "test1",
-			Expected: Matches{
-				Members: []Member{Member{Login: "test1", Name: "Test 1"}},
-			},
-		},
-		"LookupUserNameTest": {
-			State:  testGHState,
-			Lookup: "Test 1",
-			Expected: Matches{
-				Members: []Member{Member{Login: "test1", Name: "Test 1"}},
-			},
-		},
-		"LookupUserPartialTest": {
-			State:  testGHState,
-			Lookup: "test",
-			Expected: Matches{
-				Members: []Member{Member{Login: "test1", Name: "Test 1"}, Member{Login: "test2", Name: ""}},
-			},
-		},
-		"LookupTeamNameTest": {
-			State:  testGHState,
-			Lookup: "team1",
-			Expected: Matches{
-				Teams: []Team{Team{Name: "team1", Members: []string{"test1", "test2"}}},
-			},
-		},
-		"LookupTeamPartialTest": {
-			State:  testGHState,
-			Lookup: "team",
-			Expected: Matches{
-				Teams: []Team{Team{Name: "team1", Members: []string{"test1", "test2"}}, Team{Name: "team2", Members: []string{}}},
-			},
-		},
-	}
-
-	for name, c := range cases {
-		t.Run(name, func(t *testing.T) {
-			got := c.State.GetMatches(c.Lookup)
-			t.Logf("Name: %s, got: %+v, expected: %+v", name, got, c.Expected)
-			if !checkMembers(got.Members, c.Expected.Members) {
-				t.Errorf("Name: %s members, got: %+v, expected %+v", name, got, c.Expected)
-			}
-			if !checkTeams(got.Teams, c.Expected.Teams) {
-				t.Errorf("Name: %s teams, got: %+v, expected %+v", name, got, c.Expected)
-			}
-		})
-	}
-}
-
 func TestIsMember(t *testing.T) {
 	testGHState := &GH{}
 	testGHState.Members = []Member{Member{Login: "test1", Name: "Test 1"}, Member{Login: "test2", Name: ""}}
@@ -186,13 +119,336 @@ func TestGetTeamMembers(t *testing.T) {
 	}
 }
 
+func TestTeamsBySize(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.Info.Teams = []Team{
+		Team{Name: "small", Members: []string{"test1"}},
+		Team{Name: "empty", Members: []string{}},
+		Team{Name: "large", Members: []string{"test1", "test2", "test3"}},
+		Team{Name: "also-small", Members: []string{"test2"}},
+	}
+
+	got := testGHState.TeamsBySize()
+	expected := []string{"large", "also-small", "small", "empty"}
+	if len(got) != len(expected) {
+		t.Fatalf("got: %+v, expected: %v", got, expected)
+	}
+	for i := range got {
+		if got[i].Name != expected[i] {
+			t.Errorf("got: %+v, expected: %v", got, expected)
+		}
+	}
+	if testGHState.Info.Teams[0].Name != "small" {
+		t.Errorf("got: %+v, expected the teams to be left in place", testGHState.Info.Teams)
+	}
+}
+
+func TestGetMembersByState(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.Members = []Member{Member{Login: "test1", State: MemberStateActive}, Member{Login: "test2", State: MemberStatePending}, Member{Login: "test3"}}
+
+	cases := map[string]struct {
+		State    *GH
+		Lookup   string
+		Expected []Member
+	}{
+		"TestActive": {
+			State:    testGHState,
+			Lookup:   MemberStateActive,
+			Expected: []Member{Member{Login: "test1"}, Member{Login: "test3"}},
+		},
+		"TestPending": {
+			State:    testGHState,
+			Lookup:   MemberStatePending,
+			Expected: []Member{Member{Login: "test2"}},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := c.State.GetMembersByState(c.Lookup)
+			if !checkMembers(got, c.Expected) {
+				t.Errorf("Name: %s, got: %+v, expected: %+v", name, got, c.Expected)
+			}
+		})
+	}
+}
+
+func TestActiveSince(t *testing.T) {
+	now := time.Now()
+	recent, old := now.Add(-24*time.Hour), now.Add(-365*24*time.Hour)
+	testGHState := &GH{}
+	testGHState.Members = []Member{
+		memberFromUser("test1", MemberStateActive, &github.User{UpdatedAt: &github.Timestamp{Time: recent}}),
+		memberFromUser("test2", MemberStateActive, &github.User{UpdatedAt: &github.Timestamp{Time: old}}),
+		Member{Login: "test3", State: MemberStatePending},
+	}
+
+	cases := map[string]struct {
+		Since    time.Time
+		Expected []Member
+	}{
+		"TestRecent":    {Since: now.Add(-30 * 24 * time.Hour), Expected: []Member{Member{Login: "test1"}, Member{Login: "test3"}}},
+		"TestExact":     {Since: recent, Expected: []Member{Member{Login: "test1"}, Member{Login: "test3"}}},
+		"TestEveryone":  {Since: old, Expected: []Member{Member{Login: "test1"}, Member{Login: "test2"}, Member{Login: "test3"}}},
+		"TestNoneSince": {Since: now, Expected: []Member{Member{Login: "test3"}}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := testGHState.ActiveSince(c.Since)
+			if !checkMembers(got, c.Expected) {
+				t.Errorf("Name: %s, got: %+v, expected: %+v", name, got, c.Expected)
+			}
+		})
+	}
+
+	if m := memberFromUser("test4", MemberStateActive, &github.User{}); m.LastActive != nil {
+		t.Errorf("got: %v, expected no LastActive without an update time", m.LastActive)
+	}
+}
+
+func TestGetMembersByEmailDomain(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.Members = []Member{
+		Member{Login: "test1", Email: "test1@example.com"},
+		Member{Login: "test2", Email: "Test2@Eng.Example.com"},
+		Member{Login: "test3", Email: "test3@notexample.com"},
+		Member{Login: "test4"},
+	}
+
+	cases := map[string]struct {
+		Domain   string
+		Expected []Member
+	}{
+		"TestDomain":    {Domain: "example.com", Expected: []Member{Member{Login: "test1"}, Member{Login: "test2"}}},
+		"TestAtPrefix":  {Domain: "@EXAMPLE.com", Expected: []Member{Member{Login: "test1"}, Member{Login: "test2"}}},
+		"TestSubdomain": {Domain: "eng.example.com", Expected: []Member{Member{Login: "test2"}}},
+		"TestEmpty":     {Domain: "", Expected: []Member{}},
+		"TestMissing":   {Domain: "example.org", Expected: []Member{}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := testGHState.GetMembersByEmailDomain(c.Domain)
+			if !checkMembers(got, c.Expected) {
+				t.Errorf("Name: %s, got: %+v, expected: %+v", name, got, c.Expected)
+			}
+		})
+	}
+}
+
+func TestMemberLogins(t *testing.T) {
+	testGHState := &GH{}
+	if got := testGHState.MemberLogins(); got == nil || len(got) != 0 {
+		t.Errorf("got: %#v, expected an empty list", got)
+	}
+
+	testGHState.Members = []Member{Member{Login: "test2", Name: "Test 2"}, Member{Login: "Test3"}, Member{Login: "test1"}}
+	got := testGHState.MemberLogins()
+	expected := []string{"Test3", "test1", "test2"}
+	if len(got) != len(expected) {
+		t.Fatalf("got: %v, expected: %v", got, expected)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("got: %v, expected: %v", got, expected)
+		}
+	}
+}
+
+func TestMembersWithoutTeams(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.setInfo(Info{
+		Members: []Member{Member{Login: "test1"}, Member{Login: "Test2"}, Member{Login: "test3"}, Member{Login: "test4"}},
+		Teams: []Team{
+			Team{Name: "team1", Members: []string{"test1", "test2"}},
+			Team{Name: "team2", Members: []string{}},
+			Team{Name: "team3", Members: []string{"notamember"}},
+		},
+	})
+
+	got := testGHState.MembersWithoutTeams()
+	if !checkMembers(got, []Member{Member{Login: "test3"}, Member{Login: "test4"}}) {
+		t.Errorf("got: %+v, expected test3 and test4", got)
+	}
+
+	if got := (&GH{}).MembersWithoutTeams(); got == nil || len(got) != 0 {
+		t.Errorf("got: %#v, expected an empty list", got)
+	}
+}
+
+func TestGetMemberByID(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.Members = []Member{Member{ID: 1, Login: "test1", Name: "Test 1"}, Member{ID: 2, Login: "renamed", Name: "Test 2"}, Member{Login: "test3"}}
+
+	cases := map[string]struct {
+		ID       int64
+		Expected string
+		Found    bool
+	}{
+		"TestFound":   {ID: 2, Expected: "renamed", Found: true},
+		"TestMissing": {ID: 3},
+		"TestZero":    {ID: 0},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, ok := testGHState.GetMemberByID(c.ID)
+			if ok != c.Found || got.Login != c.Expected {
+				t.Errorf("Name: %s, got: %+v %v, expected: %s %v", name, got, ok, c.Expected, c.Found)
+			}
+		})
+	}
+}
+
+func TestGetMemberTeams(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.Members = []Member{Member{Login: "test1", Name: "Test 1"}, Member{Login: "test2", Name: ""}}
+	testGHState.Info.Teams = []Team{Team{Name: "team1", Members: []string{"test1", "test2"}}, Team{Name: "team2", Members: []string{"Test1"}}}
+	testGHState.indexTeams()
+
+	cases := map[string]struct {
+		State    *GH
+		Lookup   string
+		Expected []string
+	}{
+		"TestMultipleTeams": {
+			State:    testGHState,
+			Lookup:   "TEST1",
+			Expected: []string{"team1", "team2"},
+		},
+		"TestSingleTeam": {
+			State:    testGHState,
+			Lookup:   "test2",
+			Expected: []string{"team1"},
+		},
+		"TestMissing": {
+			State:    testGHState,
+			Lookup:   "notthere",
+			Expected: []string{},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := c.State.GetMemberTeams(c.Lookup)
+			if len(got) != len(c.Expected) {
+				t.Fatalf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+			}
+			for i := range got {
+				if got[i] != c.Expected[i] {
+					t.Errorf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+				}
+			}
+		})
+	}
+}
+
 type UsersServiceTester struct {
 	Login string
+	Name  string
 	Err   error
 }
 
 func (u UsersServiceTester) Get(ctx context.Context, name string) (*github.User, *github.Response, error) {
-	return &github.User{Login: &u.Login}, nil, u.Err
+	return &github.User{Login: &u.Login, Name: &u.Name}, nil, u.Err
+}
+
+// testMembershipServer answers the org membership check for the test org, with only logins as members
+// restoreEnv returns a func that puts the environment variable key back as it was, unsetting it when it
+// wasn't set since an empty value still counts as set
+func restoreEnv(key string) func() {
+	orig, ok := os.LookupEnv(key)
+	return func() {
+		if ok {
+			os.Setenv(key, orig)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func testMembershipServer(logins ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, login := range logins {
+			if r.URL.Path == "/orgs/test/members/"+login {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+}
+
+func TestGetChildTeams(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.Info.Teams = []Team{
+		Team{ID: 1, Name: "engineering", Slug: "engineering"},
+		Team{ID: 2, Name: "platform", Slug: "platform", ParentID: 1},
+		Team{ID: 3, Name: "data", Slug: "data", ParentID: 1},
+		Team{ID: 4, Name: "sre", Slug: "sre", ParentID: 2},
+		Team{Name: "old"},
+	}
+
+	cases := map[string]struct {
+		Parent   string
+		Expected []string
+	}{
+		"TestChildren":   {Parent: "Engineering", Expected: []string{"data", "platform"}},
+		"TestGrandchild": {Parent: "platform", Expected: []string{"sre"}},
+		"TestLeaf":       {Parent: "sre", Expected: []string{}},
+		"TestNoID":       {Parent: "old", Expected: []string{}},
+		"TestMissing":    {Parent: "notthere", Expected: []string{}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := testGHState.GetChildTeams(c.Parent)
+			if len(got) != len(c.Expected) {
+				t.Fatalf("Name: %s, got: %+v, expected: %v", name, got, c.Expected)
+			}
+			for i := range got {
+				if got[i].Name != c.Expected[i] {
+					t.Errorf("Name: %s, got: %+v, expected: %v", name, got, c.Expected)
+				}
+			}
+		})
+	}
+}
+
+func TestGetTeamsByRepo(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.Org = "dsc"
+	testGHState.Info.Teams = []Team{
+		Team{Name: "team2", Repos: []string{"psst"}},
+		Team{Name: "team1", Repos: []string{"Psst", "other"}},
+		Team{Name: "team3"},
+	}
+
+	cases := map[string]struct {
+		Repo     string
+		Expected []string
+	}{
+		"TestRepo":      {Repo: "psst", Expected: []string{"team1", "team2"}},
+		"TestOwnerRepo": {Repo: "DSC/other", Expected: []string{"team1"}},
+		"TestOtherOrg":  {Repo: "elsewhere/psst", Expected: []string{}},
+		"TestMissing":   {Repo: "notthere", Expected: []string{}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := testGHState.GetTeamsByRepo(c.Repo)
+			if len(got) != len(c.Expected) {
+				t.Fatalf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+			}
+			for i := range got {
+				if got[i].Name != c.Expected[i] {
+					t.Errorf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+				}
+			}
+		})
+	}
 }
 
 func TestWhoami(t *testing.T) {
@@ -247,55 +503,513 @@ func TestWhoami(t *testing.T) {
 	}
 }
 
-func checkMembers(g []Member, e []Member) bool {
-	if len(g) != len(e) {
-		return false
-	}
+// blockingUsersService never answers until the context is done
+type blockingUsersService struct{}
 
-	for _, s := range g {
-		found := false
-		for _, q := range e {
-			if s.Login == q.Login && s.Name == q.Name {
-				found = true
-				break
-			}
-		}
-		if !found {
-			return false
-		}
-	}
+func (blockingUsersService) Get(ctx context.Context, name string) (*github.User, *github.Response, error) {
+	<-ctx.Done()
+	return nil, nil, ctx.Err()
+}
 
-	for _, s := range e {
-		found := false
-		for _, q := range g {
-			if s.Login == q.Login && s.Name == q.Name {
-				found = true
-				break
-			}
-		}
-		if !found {
-			return false
-		}
+func TestWhoamiTimeout(t *testing.T) {
+	g := &GH{UsersService: blockingUsersService{}}
+	WithTimeout(20 * time.Millisecond)(&g.opts)
+
+	start := time.Now()
+	_, err := g.Whoami()
+	if errors.Cause(err) != context.DeadlineExceeded {
+		t.Errorf("got: %v, expected the deadline to be reported", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("got: %v, expected Whoami to give up at the timeout", time.Since(start))
 	}
 
-	return true
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.WhoamiContext(ctx); errors.Cause(err) != context.Canceled {
+		t.Errorf("got: %v, expected the cancellation to be reported", err)
+	}
 }
 
-func checkTeams(g []Team, e []Team) bool {
-	if len(g) != len(e) {
-		return false
+func TestMyMembership(t *testing.T) {
+	cases := map[string]struct {
+		Response string
+		Err      bool
+		State    string
+		Role     string
+	}{
+		"TestAdmin":     {Response: `{"state":"active","role":"admin","organization":{"login":"test"}}`, State: "active", Role: "admin"},
+		"TestMember":    {Response: `{"state":"pending","role":"member","organization":{"login":"test"}}`, State: "pending", Role: "member"},
+		"TestNonMember": {Err: true},
 	}
 
-	for _, s := range g {
-		found := false
-		for _, q := range e {
-			if s.Name == q.Name {
-				found = true
-				break
+	for name, c := range cases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/user/memberships/orgs/test" && c.Response != "" {
+				fmt.Fprint(w, c.Response)
+				return
+			}
+			http.NotFound(w, r)
+		}))
+
+		g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+		g.Org = "test"
+		g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+
+		membership, err := g.MyMembership()
+		server.Close()
+		if c.Err {
+			if err == nil || StatusCode(err) != http.StatusNotFound {
+				t.Errorf("Name: %s, got: %v, expected a not found error", name, err)
 			}
+			continue
 		}
-		if !found {
-			return false
+		if err != nil {
+			t.Errorf("Name: %s, unexpected error: %v", name, err)
+			continue
+		}
+		if membership.GetState() != c.State || membership.GetRole() != c.Role {
+			t.Errorf("Name: %s, got: %s %s, expected: %s %s", name, membership.GetState(), membership.GetRole(), c.State, c.Role)
+		}
+	}
+}
+
+func TestIsMemberLive(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/orgs/test/members/member":
+			w.WriteHeader(http.StatusNoContent)
+		case "/orgs/test/members/public", "/orgs/test/members/private":
+			// GitHub redirects to the public membership check when the token isn't an org member
+			http.Redirect(w, r, strings.Replace(r.URL.Path, "/members/", "/public_members/", 1), http.StatusFound)
+		case "/orgs/test/public_members/public":
+			w.WriteHeader(http.StatusNoContent)
+		case "/orgs/test/members/broken":
+			http.Error(w, `{"message":"Server Error"}`, http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+	g.Org = "test"
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+	WithRetryPolicy(RetryPolicy{MaxAttempts: 1})(&g.opts)
+
+	cases := map[string]struct {
+		Login    string
+		Expected bool
+		Err      bool
+	}{
+		"TestMember":          {Login: "member", Expected: true},
+		"TestNotMember":       {Login: "outsider"},
+		"TestRedirectPublic":  {Login: "public", Expected: true},
+		"TestRedirectPrivate": {Login: "private"},
+		"TestServerError":     {Login: "broken", Err: true},
+	}
+
+	for name, c := range cases {
+		ok, err := g.IsMemberLive(c.Login)
+		if c.Err != (err != nil) {
+			t.Errorf("Name: %s, got: %v, expected error: %v", name, err, c.Err)
+			continue
+		}
+		if ok != c.Expected {
+			t.Errorf("Name: %s, got: %v, expected: %v", name, ok, c.Expected)
+		}
+	}
+}
+
+func TestGetRepoCollaborators(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/test/repo/collaborators", "/repos/other/repo/collaborators":
+			if r.URL.Query().Get("page") != "2" {
+				w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=2>; rel="next", <%s%s?page=2>; rel="last"`, server.URL, r.URL.Path, server.URL, r.URL.Path))
+				fmt.Fprint(w, `[{"login":"zed"},{"login":"TEST1"}]`)
+				return
+			}
+			fmt.Fprint(w, `[{"login":"amy","avatar_url":"https://example.com/amy.png"}]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+	g.Org = "test"
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+	g.Members = []Member{Member{Login: "test1", Name: "Test 1"}}
+
+	cases := map[string]struct {
+		Repo string
+		Err  bool
+	}{
+		"TestOrgRepo":     {Repo: "repo"},
+		"TestOwnerRepo":   {Repo: "other/repo"},
+		"TestMissingRepo": {Repo: "missing", Err: true},
+	}
+
+	for name, c := range cases {
+		members, err := g.GetRepoCollaborators(c.Repo)
+		if c.Err {
+			if err == nil {
+				t.Errorf("Name: %s, expected an error for a missing repository", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Name: %s, unexpected error: %v", name, err)
+			continue
+		}
+		// Every page is listed and known members keep what the directory knows about them
+		checkLogins(t, name, members, []string{"amy", "test1", "zed"})
+		if members[0].AvatarURL == "" || members[1].Name != "Test 1" {
+			t.Errorf("Name: %s, got: %+v, expected collaborators filled in from the response and directory", name, members)
+		}
+	}
+}
+
+func TestPendingMembers(t *testing.T) {
+	base := testGitHubServer()
+	defer base.Close()
+
+	cases := map[string]struct {
+		Opts     []Option
+		Expected []string
+		Pending  []string
+	}{
+		"TestWithoutPending": {Expected: []string{"test1", "test2"}, Pending: []string{}},
+		"TestWithPending":    {Opts: []Option{WithPendingMembers()}, Expected: []string{"invitee", "test1", "test2"}, Pending: []string{"invitee"}},
+	}
+
+	for name, c := range cases {
+		listed := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/orgs/test/invitations":
+				listed = true
+				// Invitations sent to an email address have no login and are skipped
+				fmt.Fprint(w, `[{"login":"invitee"},{"email":"someone@example.com"}]`)
+			case "/users/invitee":
+				fmt.Fprint(w, `{"login":"invitee","name":"Invitee"}`)
+			default:
+				base.Config.Handler.ServeHTTP(w, r)
+			}
+		}))
+
+		g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+		g.Org = "test"
+		g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+		g.UsersService = g.ghClient.Users
+		for _, o := range c.Opts {
+			o(&g.opts)
+		}
+
+		members, _, _, err := g.getMembers(context.Background())
+		server.Close()
+		if err != nil {
+			t.Errorf("Name: %s, unexpected error: %v", name, err)
+			continue
+		}
+		checkLogins(t, name, members, c.Expected)
+		pending := []string{}
+		for _, m := range members {
+			if m.State == MemberStatePending {
+				pending = append(pending, m.Login)
+			}
+		}
+		if strings.Join(pending, ",") != strings.Join(c.Pending, ",") {
+			t.Errorf("Name: %s, got: %v, expected pending members: %v", name, pending, c.Pending)
+		}
+		if listed != (len(c.Opts) > 0) {
+			t.Errorf("Name: %s, got: %v, expected invitations to be listed only with WithPendingMembers", name, listed)
+		}
+	}
+}
+
+func TestAPIBaseURL(t *testing.T) {
+	cases := map[string]struct {
+		Env      string
+		Expected string
+	}{
+		"TestUnset": {
+			Env:      "",
+			Expected: "",
+		},
+		"TestGitHub": {
+			Env:      "https://api.github.com",
+			Expected: "https://api.github.com/",
+		},
+		"TestEnterprise": {
+			Env:      "https://github.example.com/api/v3/",
+			Expected: "https://github.example.com/api/v3/",
+		},
+	}
+
+	defer restoreEnv("GITHUB_API_URL")()
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if c.Env == "" {
+				os.Unsetenv("GITHUB_API_URL")
+			} else {
+				os.Setenv("GITHUB_API_URL", c.Env)
+			}
+			got, err := apiBaseURL()
+			if err != nil {
+				t.Fatalf("Name: %s, unexpected error: %v", name, err)
+			}
+			if got == nil {
+				if c.Expected != "" {
+					t.Errorf("Name: %s, got: nil, expected: %s", name, c.Expected)
+				}
+				return
+			}
+			if got.String() != c.Expected {
+				t.Errorf("Name: %s, got: %s, expected: %s", name, got, c.Expected)
+			}
+		})
+	}
+}
+
+func TestCounts(t *testing.T) {
+	g := &GH{}
+	if g.MemberCount() != 0 || g.TeamCount() != 0 {
+		t.Errorf("got: %d members and %d teams, expected none", g.MemberCount(), g.TeamCount())
+	}
+
+	g.setInfo(Info{Members: []Member{Member{Login: "test1"}, Member{Login: "test2"}}, Teams: []Team{Team{Name: "team1"}}})
+	if g.MemberCount() != 2 || g.TeamCount() != 1 {
+		t.Errorf("got: %d members and %d teams, expected 2 and 1", g.MemberCount(), g.TeamCount())
+	}
+}
+
+func TestSetInfo(t *testing.T) {
+	g := &GH{}
+	g.Members = []Member{Member{Login: "test1"}}
+	g.Info.Teams = []Team{Team{Name: "team1", Members: []string{"test1"}}}
+	g.indexTeams()
+
+	before := g.GetTeams()
+	g.setInfo(Info{
+		Members: []Member{Member{Login: "test1"}, Member{Login: "test2"}},
+		Teams:   []Team{Team{Name: "team2", Members: []string{"test2"}}},
+	})
+
+	if len(before) != 1 || before[0].Name != "team1" {
+		t.Errorf("expected previously returned teams to be unchanged, got: %+v", before)
+	}
+	if _, ok := g.IsMember("test2"); !ok {
+		t.Errorf("expected test2 to be a member after setInfo")
+	}
+	if teams := g.GetMemberTeams("test2"); len(teams) != 1 || teams[0] != "team2" {
+		t.Errorf("expected the team index to be rebuilt, got: %v", teams)
+	}
+	if teams := g.GetMemberTeams("test1"); len(teams) != 0 {
+		t.Errorf("expected stale index entries to be dropped, got: %v", teams)
+	}
+}
+
+func TestMemberSort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	loginOrder := []Member{Member{Login: "amy", Name: "Bob"}, Member{Login: "nobody"}, Member{Login: "zed", Name: "alice"}}
+	nameOrder := []Member{Member{Login: "zed", Name: "alice"}, Member{Login: "amy", Name: "Bob"}, Member{Login: "nobody"}}
+
+	cases := map[string]struct {
+		Sort     MemberSort
+		Members  []Member
+		Expected []string
+	}{
+		"TestLogin": {Sort: MemberSortLogin, Members: loginOrder, Expected: []string{"amy", "bea", "nobody", "zed"}},
+		"TestName":  {Sort: MemberSortName, Members: loginOrder, Expected: []string{"bea", "zed", "amy", "nobody"}},
+		// The cache or a snapshot written by a client sorting by name
+		"TestLoginFromNameOrder": {Sort: MemberSortLogin, Members: nameOrder, Expected: []string{"amy", "bea", "nobody", "zed"}},
+	}
+
+	server := testMembershipServer("bea")
+	defer server.Close()
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			g := &GH{ghClient: github.NewClient(nil), Info: Info{Org: "test"}}
+			g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+			WithMemberSort(c.Sort)(&g.opts)
+			// Each case gets its own cache, as every org would
+			WithCacheDir(filepath.Join(dir, name))(&g.opts)
+			if err := os.MkdirAll(g.orgCacheDir(), 0700); err != nil {
+				t.Fatalf("Name: %s, unable to create cache dir: %v", name, err)
+			}
+
+			members := make([]Member, len(c.Members))
+			copy(members, c.Members)
+			g.setInfo(Info{Members: members})
+			// A member added later is inserted in the same order
+			g.UsersService = UsersServiceTester{Login: "bea", Name: "Alice"}
+			if _, err := g.AddMemberLive("bea"); err != nil {
+				t.Fatalf("Name: %s, unexpected error: %v", name, err)
+			}
+			checkLogins(t, name, g.GetMembers(), c.Expected)
+		})
+	}
+}
+
+func TestStartAutoRefresh(t *testing.T) {
+	base := testGitHubServer()
+	defer base.Close()
+
+	var mu sync.Mutex
+	refreshes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/orgs/test/members" {
+			mu.Lock()
+			refreshes++
+			mu.Unlock()
+		}
+		base.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return refreshes
+	}
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	newState := func() *GH {
+		g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions(), done: make(chan struct{})}
+		g.Org = "test"
+		g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+		g.UsersService = g.ghClient.Users
+		WithCacheDir(dir)(&g.opts)
+		WithLogger(&testLogger{})(&g.opts)
+		return g
+	}
+	// waitFor polls until at least n refreshes have happened
+	waitFor := func(name string, n int) {
+		deadline := time.Now().Add(2 * time.Second)
+		for count() < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("Name: %s, got: %d refreshes, expected at least %d", name, count(), n)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	// checkStopped makes sure no refreshes happen after the loop was told to stop
+	checkStopped := func(name string) {
+		// Let a refresh that was already in flight finish
+		time.Sleep(50 * time.Millisecond)
+		stopped := count()
+		time.Sleep(100 * time.Millisecond)
+		if count() != stopped {
+			t.Errorf("Name: %s, got: %d refreshes after stopping, expected none", name, count()-stopped)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g := newState()
+	g.StartAutoRefresh(ctx, 10*time.Millisecond)
+	waitFor("TestCancel", 2)
+	checkLogins(t, "TestCancel", g.GetMembers(), []string{"test1", "test2"})
+	cancel()
+	checkStopped("TestCancel")
+
+	start := count()
+	g = newState()
+	g.StartAutoRefresh(context.Background(), 10*time.Millisecond)
+	waitFor("TestClose", start+2)
+	if err := g.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkStopped("TestClose")
+
+	g = newState()
+	g.StartAutoRefresh(context.Background(), 0)
+	time.Sleep(50 * time.Millisecond)
+	if len(g.GetMembers()) != 0 {
+		t.Errorf("got: %v, expected no refresh without an interval", g.GetMembers())
+	}
+}
+
+func TestClose(t *testing.T) {
+	g := &GH{done: make(chan struct{})}
+	if err := g.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case <-g.done:
+	default:
+		t.Errorf("expected done to be closed")
+	}
+	// Closing twice must not panic
+	if err := g.Close(); err != nil {
+		t.Errorf("unexpected error on second close: %v", err)
+	}
+	if err := (&GH{}).Close(); err != nil {
+		t.Errorf("unexpected error closing an empty client: %v", err)
+	}
+}
+
+func checkMembers(g []Member, e []Member) bool {
+	if len(g) != len(e) {
+		return false
+	}
+
+	for _, s := range g {
+		found := false
+		for _, q := range e {
+			if s.Login == q.Login && s.Name == q.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for _, s := range e {
+		found := false
+		for _, q := range g {
+			if s.Login == q.Login && s.Name == q.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func checkTeams(g []Team, e []Team) bool {
+	if len(g) != len(e) {
+		return false
+	}
+
+	for _, s := range g {
+		found := false
+		for _, q := range e {
+			if s.Name == q.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
 		}
 	}
 
@@ -314,3 +1028,1095 @@ func checkTeams(g []Team, e []Team) bool {
 
 	return true
 }
+
+func TestNewGitHubContextCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(orig string) { cacheDir = orig }(cacheDir)
+	cacheDir = dir
+
+	defer restoreEnv("GITHUB_TOKEN")()
+	defer restoreEnv("GITHUB_API_URL")()
+	os.Setenv("GITHUB_TOKEN", "test")
+	os.Setenv("GITHUB_API_URL", "http://127.0.0.1:1/")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	g, err := NewGitHubContext(ctx, "test")
+	if err == nil {
+		t.Fatalf("expected an error from a cancelled context")
+	}
+	defer g.Close()
+	if !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("got: %v, expected the fetch to stop because the context was cancelled", err)
+	}
+}
+
+func TestGetTeamTimes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/teams/42" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"id":42,"name":"team1","created_at":"2017-06-01T10:00:00Z","updated_at":"2018-05-16T12:30:00Z"}`)
+	}))
+	defer server.Close()
+
+	g := &GH{ghClient: github.NewClient(nil)}
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+
+	team := Team{ID: 42, Name: "team1"}
+	if err := g.getTeamTimes(context.Background(), &team); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if team.CreatedAt == nil || team.UpdatedAt == nil {
+		t.Fatalf("got: %+v, expected both times to be set", team)
+	}
+	if !team.CreatedAt.Equal(time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("got created: %v, expected 2017-06-01 10:00", team.CreatedAt)
+	}
+	if !team.UpdatedAt.Equal(time.Date(2018, 5, 16, 12, 30, 0, 0, time.UTC)) {
+		t.Errorf("got updated: %v, expected 2018-05-16 12:30", team.UpdatedAt)
+	}
+
+	missing := Team{ID: 7}
+	if err := g.getTeamTimes(context.Background(), &missing); err == nil {
+		t.Errorf("expected an error for a team that doesn't exist")
+	}
+}
+
+func TestMaxTeams(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/orgs/test/teams":
+			fmt.Fprint(w, `[{"id":1,"name":"team1"},{"id":2,"name":"team2"},{"id":3,"name":"team3"}]`)
+		case strings.HasSuffix(r.URL.Path, "/members"):
+			fmt.Fprint(w, `[]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cases := map[string]struct {
+		Max      int
+		Expected int
+		Capped   bool
+	}{
+		"TestUnlimited": {Max: 0, Expected: 3},
+		"TestLimited":   {Max: 2, Expected: 2, Capped: true},
+		"TestExact":     {Max: 3, Expected: 3},
+		"TestAboveAll":  {Max: 5, Expected: 3},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+			g.Org = "test"
+			g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+			WithMaxTeams(c.Max)(&g.opts)
+
+			teams, capped, err := g.getTeams(context.Background())
+			if err != nil {
+				t.Fatalf("Name: %s, unexpected error: %v", name, err)
+			}
+			if len(teams) != c.Expected {
+				t.Errorf("Name: %s, got: %+v, expected %d teams", name, teams, c.Expected)
+			}
+			if capped != c.Capped {
+				t.Errorf("Name: %s, got capped: %v, expected: %v", name, capped, c.Capped)
+			}
+		})
+	}
+}
+
+func TestTeamFilter(t *testing.T) {
+	var mu sync.Mutex
+	looked := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/orgs/test/teams":
+			fmt.Fprint(w, `[{"id":1,"name":"sre-core","slug":"sre-core"},{"id":2,"name":"SRE Oncall","slug":"sre-oncall"},{"id":3,"name":"platform","slug":"platform"}]`)
+		case strings.HasSuffix(r.URL.Path, "/members"):
+			mu.Lock()
+			looked[r.URL.Path] = true
+			mu.Unlock()
+			fmt.Fprint(w, `[]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+	g.Org = "test"
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+	WithTeamFilter("SRE-")(&g.opts)
+
+	teams, _, err := g.getTeams(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(teams) != 2 || teams[0].Slug != "sre-oncall" || teams[1].Slug != "sre-core" {
+		t.Errorf("got: %+v, expected only the sre teams", teams)
+	}
+	if looked["/teams/3/members"] {
+		t.Errorf("expected the members of filtered out teams not to be looked up")
+	}
+}
+
+func TestTeamRepos(t *testing.T) {
+	cases := map[string]struct {
+		Opts     []Option
+		Expected []string
+	}{
+		"TestWithoutRepos": {},
+		"TestWithRepos":    {Opts: []Option{WithTeamRepos()}, Expected: []string{"api", "web"}},
+	}
+
+	for name, c := range cases {
+		listed := false
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/orgs/test/teams":
+				fmt.Fprint(w, `[{"id":1,"name":"team1"}]`)
+			case "/teams/1/members":
+				fmt.Fprint(w, `[{"login":"test1"}]`)
+			case "/teams/1/repos":
+				listed = true
+				if r.URL.Query().Get("page") != "2" {
+					w.Header().Set("Link", fmt.Sprintf(`<%s/teams/1/repos?page=2>; rel="next"`, server.URL))
+					fmt.Fprint(w, `[{"name":"api"}]`)
+					return
+				}
+				fmt.Fprint(w, `[{"name":"web"}]`)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+
+		g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+		g.Org = "test"
+		g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+		for _, o := range c.Opts {
+			o(&g.opts)
+		}
+
+		teams, _, err := g.getTeams(context.Background())
+		server.Close()
+		if err != nil {
+			t.Errorf("Name: %s, unexpected error: %v", name, err)
+			continue
+		}
+		if len(teams) != 1 || strings.Join(teams[0].Repos, ",") != strings.Join(c.Expected, ",") {
+			t.Errorf("Name: %s, got: %+v, expected repos: %v", name, teams, c.Expected)
+		}
+		if listed != (len(c.Opts) > 0) {
+			t.Errorf("Name: %s, got: %v, expected repos to be listed only with WithTeamRepos", name, listed)
+		}
+	}
+}
+
+func TestRefreshTeam(t *testing.T) {
+	server := testGitHubServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+	g.Org = "test"
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+	WithCacheDir(dir)(&g.opts)
+	if err := g.createCacheDir(); err != nil {
+		t.Fatalf("unable to create cache dir: %v", err)
+	}
+	g.setInfo(Info{
+		Org:     "test",
+		Members: []Member{Member{Login: "test1"}, Member{Login: "test2"}},
+		Teams:   []Team{Team{ID: 1, Name: "team1", Members: []string{"test1"}}, Team{ID: 2, Name: "team2", Members: []string{"test2"}}},
+	})
+	before := g.GetTeams()
+
+	if err := g.RefreshTeam("nosuchteam"); err == nil {
+		t.Errorf("expected an error for a team that isn't in the directory")
+	}
+	if _, err := os.Stat(g.cacheFile(teamsCacheFile)); !os.IsNotExist(err) {
+		t.Errorf("got: %v, expected a failed refresh not to write the cache", err)
+	}
+	if err := g.RefreshTeam("team1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := g.GetTeamMembers("team1"); strings.Join(got, ",") != "test1,test2" {
+		t.Errorf("got: %v, expected team1 to be refreshed", got)
+	}
+	if got := g.GetTeamMembers("team2"); strings.Join(got, ",") != "test2" {
+		t.Errorf("got: %v, expected team2 to be left alone", got)
+	}
+	if got := g.GetMemberTeams("test2"); len(got) != 2 {
+		t.Errorf("got: %v, expected the team index to be rebuilt", got)
+	}
+	if len(before[0].Members) != 1 {
+		t.Errorf("got: %v, expected previously returned teams to be unchanged", before[0].Members)
+	}
+
+	var cached []Team
+	if err := g.getCached(g.cacheFile(teamsCacheFile), &cached); err != nil {
+		t.Fatalf("unable to read cache: %v", err)
+	}
+	if len(cached) != 2 || strings.Join(cached[0].Members, ",") != "test1,test2" {
+		t.Errorf("got: %+v, expected the refreshed team to be cached", cached)
+	}
+}
+
+func TestRefreshTeamWithTeamFilter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/teams/2/members" {
+			fmt.Fprint(w, `[{"login":"test1"},{"login":"test2"}]`)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+	g.Org = "test"
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+	WithCacheDir(dir)(&g.opts)
+	if err := g.createCacheDir(); err != nil {
+		t.Fatalf("unable to create cache dir: %v", err)
+	}
+	full := []Team{Team{ID: 1, Name: "platform", Members: []string{"test1"}}, Team{ID: 2, Name: "sre-core", Members: []string{"test1"}}}
+	info := Info{Org: "test", Members: []Member{Member{Login: "test1"}, Member{Login: "test2"}}, Teams: full, ActiveMemberTeams: []string{}}
+	if err := g.saveInfo(info, g.cacheFile(membersCacheFile), g.cacheFile(teamsCacheFile), g.cacheFile(activeMembershipsCacheFile), g.cacheFile(orgCacheFile)); err != nil {
+		t.Fatalf("unable to save cache: %v", err)
+	}
+
+	// A filtered client only knows the sre teams, as it would after loading the cache
+	WithTeamFilter("sre-")(&g.opts)
+	info.Teams = full[1:]
+	g.setInfo(info)
+	if err := g.RefreshTeam("sre-core"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := g.GetTeamMembers("sre-core"); len(got) != 2 {
+		t.Errorf("got: %v, expected sre-core to be refreshed", got)
+	}
+
+	var cached []Team
+	if err := g.getCached(g.cacheFile(teamsCacheFile), &cached); err != nil {
+		t.Fatalf("unable to read cache: %v", err)
+	}
+	if len(cached) != 2 {
+		t.Errorf("got: %+v, expected the filtered teams not to replace the cached teams", cached)
+	}
+}
+
+func TestGetTeamsCancelsOnError(t *testing.T) {
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/orgs/test/teams":
+			fmt.Fprint(w, `[{"id":1,"name":"team1"},{"id":2,"name":"team2"}]`)
+		case "/teams/1/members":
+			// Hold the lookup until the failure of team2 cancels it
+			select {
+			case <-r.Context().Done():
+				close(cancelled)
+			case <-time.After(5 * time.Second):
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+	g.Org = "test"
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+	WithStrictTeams()(&g.opts)
+
+	if _, _, err := g.getTeams(context.Background()); err == nil {
+		t.Fatalf("expected an error for the missing team")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Errorf("expected the lookup still in flight to be cancelled")
+	}
+}
+
+func TestIncompleteTeams(t *testing.T) {
+	base := testGitHubServer()
+	defer base.Close()
+
+	cases := map[string]struct {
+		failing    map[string]bool
+		opts       []Option
+		err        bool
+		incomplete []string
+		noMembers  []string
+	}{
+		"TestNoneFail": {failing: map[string]bool{}, incomplete: []string{}, noMembers: []string{}},
+		"TestOneFails": {failing: map[string]bool{"/teams/2/members": true}, incomplete: []string{"team2"}, noMembers: []string{"team2"}},
+		"TestAllFail":  {failing: map[string]bool{"/teams/1/members": true, "/teams/2/members": true}, err: true},
+		// Only failing to get members counts towards every team failing
+		"TestReposFail": {
+			failing:    map[string]bool{"/teams/1/repos": true, "/teams/2/repos": true},
+			opts:       []Option{WithTeamRepos()},
+			incomplete: []string{"team1", "team2"},
+			noMembers:  []string{},
+		},
+	}
+
+	for name, c := range cases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/orgs/test/teams":
+				fmt.Fprint(w, `[{"id":1,"name":"team1"},{"id":2,"name":"team2","privacy":"secret"}]`)
+			case c.failing[r.URL.Path]:
+				http.Error(w, `{"message":"Forbidden"}`, http.StatusForbidden)
+			case strings.HasPrefix(r.URL.Path, "/teams/"):
+				fmt.Fprint(w, `[{"login":"test1"}]`)
+			default:
+				base.Config.Handler.ServeHTTP(w, r)
+			}
+		}))
+
+		dir, err := ioutil.TempDir("", "psst-cache")
+		if err != nil {
+			t.Fatalf("unable to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+
+		log := &testLogger{}
+		g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+		g.Org = "test"
+		g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+		g.UsersService = g.ghClient.Users
+		WithCacheDir(dir)(&g.opts)
+		WithLogger(log)(&g.opts)
+		for _, o := range c.opts {
+			o(&g.opts)
+		}
+
+		_, err = g.getMembersAndTeams(context.Background(), true)
+		server.Close()
+		if c.err {
+			if err == nil {
+				t.Errorf("Name: %s, expected an error when every team fails", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Name: %s, unexpected error: %v", name, err)
+			continue
+		}
+		teams := g.GetTeams()
+		if len(teams) != 2 {
+			t.Errorf("Name: %s, got: %+v, expected both teams", name, teams)
+			continue
+		}
+		incomplete, noMembers := []string{}, []string{}
+		for _, team := range teams {
+			if team.Incomplete {
+				incomplete = append(incomplete, team.Name)
+			}
+			if len(team.Members) == 0 {
+				noMembers = append(noMembers, team.Name)
+			}
+			if team.Empty {
+				t.Errorf("Name: %s, got: %+v, expected %s not to be marked empty", name, team, team.Name)
+			}
+		}
+		if strings.Join(incomplete, ",") != strings.Join(c.incomplete, ",") {
+			t.Errorf("Name: %s, got: %v, expected incomplete teams: %v", name, incomplete, c.incomplete)
+		}
+		if strings.Join(noMembers, ",") != strings.Join(c.noMembers, ",") {
+			t.Errorf("Name: %s, got: %v, expected teams without members: %v", name, noMembers, c.noMembers)
+		}
+		warnings := 0
+		for _, line := range log.lines {
+			if strings.Contains(line, "continuing without them") {
+				warnings++
+			}
+		}
+		if warnings != len(c.incomplete) {
+			t.Errorf("Name: %s, got: %v, expected a warning per incomplete team", name, log.lines)
+		}
+		// An incomplete team isn't hidden as empty
+		if got := g.GetMatchesWithOptions("team", MatchOptions{ExcludeEmptyTeams: true}).Teams; len(got) != 2 {
+			t.Errorf("Name: %s, got: %+v, expected both teams to match", name, got)
+		}
+	}
+}
+
+func TestRefreshResultIncompleteTeams(t *testing.T) {
+	base := testGitHubServer()
+	defer base.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/orgs/test/teams":
+			fmt.Fprint(w, `[{"id":1,"name":"team1"},{"id":2,"name":"team2"}]`)
+		case "/teams/2/members":
+			http.Error(w, `{"message":"Forbidden"}`, http.StatusForbidden)
+		default:
+			base.Config.Handler.ServeHTTP(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+	g.Org = "test"
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+	g.UsersService = g.ghClient.Users
+	WithCacheDir(dir)(&g.opts)
+	WithLogger(&testLogger{})(&g.opts)
+
+	result, err := g.getMembersAndTeams(context.Background(), true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.IncompleteTeams != 1 {
+		t.Errorf("got: %+v, expected one incomplete team", result)
+	}
+
+	// One failed lookup isn't cached for the whole TTL, the next load fetches again
+	if _, err := os.Stat(g.cacheFile(teamsCacheFile)); !os.IsNotExist(err) {
+		t.Errorf("got: %v, expected the teams not to be cached", err)
+	}
+	result, err = g.getMembersAndTeams(context.Background(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.FromCache || result.IncompleteTeams != 1 {
+		t.Errorf("got: %+v, expected one incomplete team fetched again", result)
+	}
+}
+
+func TestAddMemberLive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(orig string) { cacheDir = orig }(cacheDir)
+	cacheDir = dir
+
+	server := testMembershipServer("test2", "test3")
+	defer server.Close()
+
+	g := &GH{ghClient: github.NewClient(nil), Info: Info{Org: "test"}}
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+	if err := os.MkdirAll(g.orgCacheDir(), 0700); err != nil {
+		t.Fatalf("unable to create cache dir: %v", err)
+	}
+	g.Members = []Member{Member{Login: "test1"}, Member{Login: "test3", Name: "Old Name"}}
+	before := g.GetMembers()
+
+	g.UsersService = UsersServiceTester{Login: "test2"}
+	if _, err := g.AddMemberLive("TEST2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g.UsersService = UsersServiceTester{Login: "test3"}
+	if _, err := g.AddMemberLive("test3"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkLogins(t, "TestAdded", g.GetMembers(), []string{"test1", "test2", "test3"})
+	if g.GetMembers()[2].Name != "" {
+		t.Errorf("got: %+v, expected test3 to be replaced", g.GetMembers()[2])
+	}
+	checkLogins(t, "TestPrevious", before, []string{"test1", "test3"})
+
+	var cached []Member
+	if err := g.getCached(g.cacheFile(membersCacheFile), &cached); err != nil {
+		t.Fatalf("unable to read cache: %v", err)
+	}
+	checkLogins(t, "TestCached", cached, []string{"test1", "test2", "test3"})
+
+	g.UsersService = UsersServiceTester{Err: errors.New("not found")}
+	if _, err := g.AddMemberLive("nobody"); err == nil {
+		t.Errorf("expected a failed lookup to return an error")
+	}
+	g.UsersService = UsersServiceTester{Login: "outsider"}
+	if _, err := g.AddMemberLive("outsider"); errors.Cause(err) != ErrNotMember {
+		t.Errorf("got: %v, expected a user outside the org to be rejected", err)
+	}
+	if _, ok := g.IsMember("outsider"); ok {
+		t.Errorf("expected outsider not to become a member")
+	}
+	checkLogins(t, "TestUnchanged", g.GetMembers(), []string{"test1", "test2", "test3"})
+	if err := g.getCached(g.cacheFile(membersCacheFile), &cached); err != nil {
+		t.Fatalf("unable to read cache: %v", err)
+	}
+	checkLogins(t, "TestCachedUnchanged", cached, []string{"test1", "test2", "test3"})
+
+	// A client with limits set only knows part of the org and mustn't overwrite the cache with it
+	limited := &GH{ghClient: g.ghClient, Info: Info{Org: "test"}}
+	WithMaxMembers(1)(&limited.opts)
+	limited.Members = []Member{Member{Login: "test1"}}
+	limited.UsersService = UsersServiceTester{Login: "test2"}
+	if _, err := limited.AddMemberLive("test2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkLogins(t, "TestLimitedAdded", limited.GetMembers(), []string{"test1", "test2"})
+	cached = nil
+	if err := g.getCached(g.cacheFile(membersCacheFile), &cached); err != nil {
+		t.Fatalf("unable to read cache: %v", err)
+	}
+	checkLogins(t, "TestLimitedNotCached", cached, []string{"test1", "test2", "test3"})
+}
+
+func TestAddMemberLiveFilter(t *testing.T) {
+	base := testMembershipServer("test1", "test2")
+	defer base.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/orgs/test/memberships/test1":
+			fmt.Fprint(w, `{"role":"admin","state":"active"}`)
+		case "/orgs/test/memberships/test2":
+			fmt.Fprint(w, `{"role":"member","state":"active"}`)
+		case "/orgs/test/public_members/test1":
+			w.WriteHeader(http.StatusNoContent)
+		case "/orgs/test/members":
+			if r.URL.Query().Get("filter") != "2fa_disabled" {
+				t.Errorf("got: %s, expected only members without two-factor authentication", r.URL)
+			}
+			fmt.Fprint(w, `[{"login":"test2"}]`)
+		default:
+			base.Config.Handler.ServeHTTP(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := map[string]struct {
+		Filter   MemberFilter
+		Login    string
+		Expected error
+	}{
+		"TestRoleMatches":      {Filter: MemberFilter{Role: "admin"}, Login: "test1"},
+		"TestRoleDiffers":      {Filter: MemberFilter{Role: "admin"}, Login: "test2", Expected: ErrFilteredMember},
+		"TestPublic":           {Filter: MemberFilter{PublicOnly: true}, Login: "test1"},
+		"TestNotPublic":        {Filter: MemberFilter{PublicOnly: true}, Login: "test2", Expected: ErrFilteredMember},
+		"TestTwoFactorOff":     {Filter: MemberFilter{TwoFactorDisabled: true}, Login: "test2"},
+		"TestTwoFactorOn":      {Filter: MemberFilter{TwoFactorDisabled: true}, Login: "test1", Expected: ErrFilteredMember},
+		"TestAllRoles":         {Filter: MemberFilter{Role: "all"}, Login: "test2"},
+		"TestFilterNotMember":  {Filter: MemberFilter{Role: "admin"}, Login: "outsider", Expected: ErrNotMember},
+		"TestCombinedFiltered": {Filter: MemberFilter{Role: "admin", TwoFactorDisabled: true}, Login: "test1", Expected: ErrFilteredMember},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+			g.Org = "test"
+			g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+			WithCacheDir(filepath.Join(dir, name))(&g.opts)
+			WithMemberFilter(c.Filter)(&g.opts)
+			if err := os.MkdirAll(g.orgCacheDir(), 0700); err != nil {
+				t.Fatalf("Name: %s, unable to create cache dir: %v", name, err)
+			}
+			g.UsersService = UsersServiceTester{Login: c.Login}
+
+			_, err := g.AddMemberLive(c.Login)
+			if errors.Cause(err) != c.Expected {
+				t.Fatalf("Name: %s, got: %v, expected: %v", name, err, c.Expected)
+			}
+			expected := []string{c.Login}
+			if c.Expected != nil {
+				expected = []string{}
+			}
+			checkLogins(t, name, g.GetMembers(), expected)
+
+			// A member the filter leaves out must not end up in the filtered cache either
+			var cached []Member
+			if err := g.getCached(g.cacheFile(membersCacheFile), &cached); err != nil && !os.IsNotExist(errors.Cause(err)) {
+				t.Fatalf("Name: %s, unable to read cache: %v", name, err)
+			}
+			checkLogins(t, name, cached, expected)
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psst-token")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer restoreEnv("GITHUB_TOKEN")()
+	os.Setenv("GITHUB_TOKEN", "from-env")
+
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("  from-file\n"), 0600); err != nil {
+		t.Fatalf("unable to write token file: %v", err)
+	}
+	emptyFile := filepath.Join(dir, "empty")
+	if err := ioutil.WriteFile(emptyFile, []byte("\n"), 0600); err != nil {
+		t.Fatalf("unable to write token file: %v", err)
+	}
+
+	cases := map[string]struct {
+		Options  []Option
+		Expected string
+		Err      bool
+	}{
+		"TestEnv":         {Expected: "from-env"},
+		"TestFile":        {Options: []Option{WithTokenFile(tokenFile)}, Expected: "from-file"},
+		"TestEmptyFile":   {Options: []Option{WithTokenFile(emptyFile)}, Err: true},
+		"TestMissingFile": {Options: []Option{WithTokenFile(filepath.Join(dir, "missing"))}, Err: true},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			g := &GH{}
+			for _, opt := range c.Options {
+				opt(&g.opts)
+			}
+			got, err := g.token()
+			if (err != nil) != c.Err {
+				t.Fatalf("Name: %s, got error: %v, expected error: %v", name, err, c.Err)
+			}
+			if got != c.Expected {
+				t.Errorf("Name: %s, got: %q, expected: %q", name, got, c.Expected)
+			}
+		})
+	}
+}
+
+// testGitHubServer answers just enough of the API for a full fetch of org "test" with two members and
+// one team, which test1 maintains
+func testGitHubServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/user":
+			fmt.Fprint(w, `{"login":"test1"}`)
+		case "/orgs/test":
+			fmt.Fprint(w, `{"login":"test","default_repository_permission":"read"}`)
+		case "/orgs/test/members":
+			fmt.Fprint(w, `[{"login":"test1"},{"login":"test2"}]`)
+		case "/users/test1":
+			fmt.Fprint(w, `{"login":"test1","name":"Test 1"}`)
+		case "/users/test2":
+			fmt.Fprint(w, `{"login":"test2","name":"Test 2"}`)
+		case "/user/teams":
+			fmt.Fprint(w, `[{"id":1,"name":"team1","organization":{"login":"test"}}]`)
+		case "/orgs/test/teams":
+			fmt.Fprint(w, `[{"id":1,"name":"team1","slug":"team1"}]`)
+		case "/teams/1/members":
+			if r.URL.Query().Get("role") == "maintainer" {
+				fmt.Fprint(w, `[{"login":"test1"}]`)
+				return
+			}
+			fmt.Fprint(w, `[{"login":"test1"},{"login":"test2"}]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestWarmCache(t *testing.T) {
+	server := testGitHubServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer restoreEnv("GITHUB_TOKEN")()
+	defer restoreEnv("GITHUB_API_URL")()
+	os.Setenv("GITHUB_TOKEN", "test")
+	os.Setenv("GITHUB_API_URL", server.URL)
+
+	if err := WarmCache("test", WithCacheDir(dir)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The server is gone, so this can only succeed from the cache
+	server.Close()
+	g, err := NewGitHub("test", false, WithCacheDir(dir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer g.Close()
+	if !g.FromCache() {
+		t.Errorf("expected the warmed cache to be used")
+	}
+	checkLogins(t, "TestWarmCache", g.GetMembers(), []string{"test1", "test2"})
+	if got := g.GetTeamMembers("team1"); len(got) != 2 {
+		t.Errorf("got: %v, expected team1 to have both members", got)
+	}
+	if got := g.GetActiveMemberTeams(); len(got) != 1 || got[0] != "team1" {
+		t.Errorf("got: %v, expected the active member to be in team1", got)
+	}
+}
+
+func TestGetTeamMaintainers(t *testing.T) {
+	server := testGitHubServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer restoreEnv("GITHUB_TOKEN")()
+	defer restoreEnv("GITHUB_API_URL")()
+	os.Setenv("GITHUB_TOKEN", "test")
+	os.Setenv("GITHUB_API_URL", server.URL)
+
+	cases := map[string]struct {
+		Opts     []Option
+		Lookup   string
+		Expected []string
+	}{
+		"TestMaintainers": {
+			Opts:     []Option{WithTeamMaintainers()},
+			Lookup:   "team1",
+			Expected: []string{"test1"},
+		},
+		"TestTeamMissing": {
+			Opts:     []Option{WithTeamMaintainers()},
+			Lookup:   "notthere",
+			Expected: []string{},
+		},
+		"TestNotFetched": {
+			Lookup:   "team1",
+			Expected: []string{},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			g, err := NewGitHubContext(context.Background(), "test", append(c.Opts, WithCacheDir(dir), WithForceRefresh())...)
+			if err != nil {
+				t.Fatalf("Name: %s, unexpected error: %v", name, err)
+			}
+			defer g.Close()
+
+			got := g.GetTeamMaintainers(c.Lookup)
+			if len(got) != len(c.Expected) {
+				t.Fatalf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+			}
+			for i := range got {
+				if got[i] != c.Expected[i] {
+					t.Errorf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+				}
+			}
+		})
+	}
+}
+
+func TestInsecureTLS(t *testing.T) {
+	plain := testGitHubServer()
+	defer plain.Close()
+	server := httptest.NewTLSServer(plain.Config.Handler)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := map[string]struct {
+		Opts []Option
+		Err  bool
+	}{
+		"TestVerified": {Err: true},
+		"TestInsecure": {Opts: []Option{WithInsecureTLS()}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			opts := append(c.Opts, WithToken("test"), WithBaseURL(server.URL), WithCacheDir(dir), WithForceRefresh(),
+				WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+			g, err := NewGitHubContext(context.Background(), "test", opts...)
+			if (err != nil) != c.Err {
+				t.Fatalf("Name: %s, got error: %v, expected error: %v", name, err, c.Err)
+			}
+			g.Close()
+		})
+	}
+}
+
+func TestRefreshMembers(t *testing.T) {
+	server := testGitHubServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+	g.Org = "test"
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+	g.UsersService = g.ghClient.Users
+	WithCacheDir(dir)(&g.opts)
+	g.Members = []Member{Member{Login: "gone"}}
+	g.Info.Teams = []Team{Team{Name: "cached", Members: []string{"gone"}}}
+
+	if err := g.RefreshMembers(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkLogins(t, "TestRefreshMembers", g.GetMembers(), []string{"test1", "test2"})
+	if teams := g.GetTeams(); len(teams) != 1 || teams[0].Name != "cached" {
+		t.Errorf("got: %+v, expected the teams to be left alone", teams)
+	}
+	if got := g.GetActiveMemberTeams(); len(got) != 1 || got[0] != "team1" {
+		t.Errorf("got: %v, expected the active member's teams to be refreshed", got)
+	}
+
+	var cached []Member
+	if err := g.getCached(g.cacheFile(membersCacheFile), &cached); err != nil {
+		t.Fatalf("unable to read members cache: %v", err)
+	}
+	checkLogins(t, "TestRefreshMembersCache", cached, []string{"test1", "test2"})
+	if _, err := os.Stat(g.cacheFile(teamsCacheFile)); !os.IsNotExist(err) {
+		t.Errorf("got: %v, expected the teams cache not to be written", err)
+	}
+
+	// Limits that keep saveInfo from caching keep RefreshMembers from caching too
+	limited := &GH{ghClient: g.ghClient, opts: defaultOptions()}
+	limited.Org = "test"
+	limited.UsersService = g.ghClient.Users
+	WithCacheDir(filepath.Join(dir, "limited"))(&limited.opts)
+	WithTeamFilter("sre-")(&limited.opts)
+	if err := limited.RefreshMembers(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(limited.cacheFile(membersCacheFile)); !os.IsNotExist(err) {
+		t.Errorf("got: %v, expected the members cache not to be written with a team filter", err)
+	}
+}
+
+func TestMemberFilter(t *testing.T) {
+	base := testGitHubServer()
+	defer base.Close()
+
+	var queries []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/orgs/test/members":
+			mu.Lock()
+			queries = append(queries, r.URL.RawQuery)
+			mu.Unlock()
+			if r.URL.Query().Get("role") == "admin" {
+				fmt.Fprint(w, `[{"login":"test1"}]`)
+				return
+			}
+			if r.URL.Query().Get("filter") == "2fa_disabled" {
+				fmt.Fprint(w, `[]`)
+				return
+			}
+			fmt.Fprint(w, `[{"login":"test1"},{"login":"test2"}]`)
+		default:
+			base.Config.Handler.ServeHTTP(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := map[string]struct {
+		Filter   MemberFilter
+		Query    string
+		Expected []string
+	}{
+		"TestAll":          {Expected: []string{"test1", "test2"}},
+		"TestAllRole":      {Filter: MemberFilter{Role: "all"}, Query: "role=all", Expected: []string{"test1", "test2"}},
+		"TestAdmins":       {Filter: MemberFilter{Role: "admin"}, Query: "role=admin", Expected: []string{"test1"}},
+		"TestTwoFactorOff": {Filter: MemberFilter{TwoFactorDisabled: true}, Query: "filter=2fa_disabled", Expected: []string{}},
+	}
+
+	ids := map[string]string{}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			mu.Lock()
+			queries = nil
+			mu.Unlock()
+			g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+			g.Org = "test"
+			g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+			g.UsersService = g.ghClient.Users
+			WithCacheDir(dir)(&g.opts)
+			WithMemberFilter(c.Filter)(&g.opts)
+
+			if _, err := g.getMembersAndTeams(context.Background(), true); err != nil {
+				t.Fatalf("Name: %s, unexpected error: %v", name, err)
+			}
+			checkLogins(t, name, g.GetMembers(), c.Expected)
+			if len(queries) == 0 || !strings.Contains(queries[0], c.Query) {
+				t.Errorf("Name: %s, got queries: %v, expected them to contain %q", name, queries, c.Query)
+			}
+			if _, err := os.Stat(g.cacheFile(membersCacheFile)); err != nil {
+				t.Errorf("Name: %s, got: %v, expected the members to be cached", name, err)
+			}
+			ids[name] = g.cacheID()
+		})
+	}
+
+	if ids["TestAll"] != ids["TestAllRole"] {
+		t.Errorf("got: %v, expected role all to share the unfiltered cache", ids)
+	}
+	if ids["TestAll"] == ids["TestAdmins"] || ids["TestAdmins"] == ids["TestTwoFactorOff"] {
+		t.Errorf("got: %v, expected each filter to have its own cache", ids)
+	}
+}
+
+func TestWorkerJitter(t *testing.T) {
+	server := testGitHubServer()
+	defer server.Close()
+
+	g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+	g.Org = "test"
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+	g.UsersService = g.ghClient.Users
+	if g.opts.workerJitter != ghWorkerJitter {
+		t.Errorf("got: %v, expected the workers to be staggered by default", g.opts.workerJitter)
+	}
+
+	// Workers still waiting to start give up as soon as the fetch is cancelled
+	WithWorkerJitter(time.Hour)(&g.opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, _, err := g.getMembers(ctx); errors.Cause(err) != context.DeadlineExceeded {
+		t.Errorf("got: %v, expected the deadline to be reported", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("got: %v, expected the waiting workers to stop at the deadline", time.Since(start))
+	}
+
+	WithWorkerJitter(0)(&g.opts)
+	members, _, _, err := g.getMembers(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkLogins(t, "TestWithoutJitter", members, []string{"test1", "test2"})
+}
+
+func TestMemberHook(t *testing.T) {
+	server := testGitHubServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	slack := map[string]string{"test1": "@one", "test2": "@two"}
+	calls := 0
+	g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+	g.Org = "test"
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+	g.UsersService = g.ghClient.Users
+	WithCacheDir(dir)(&g.opts)
+	WithMemberHook(func(m Member) Member {
+		// Only ever called from one goroutine, so this needs no lock
+		calls++
+		m.Annotations = map[string]string{"slack": slack[m.Login]}
+		return m
+	})(&g.opts)
+
+	if _, err := g.getMembersAndTeams(context.Background(), true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("got: %d calls, expected one per member", calls)
+	}
+
+	var cached []Member
+	if err := g.getCached(g.cacheFile(membersCacheFile), &cached); err != nil {
+		t.Fatalf("unable to read members cache: %v", err)
+	}
+	for _, members := range [][]Member{g.GetMembers(), cached} {
+		if len(members) != 2 {
+			t.Fatalf("got: %+v, expected both members", members)
+		}
+		for _, m := range members {
+			if m.Annotations["slack"] != slack[m.Login] {
+				t.Errorf("got: %+v, expected the Slack handle %s", m, slack[m.Login])
+			}
+		}
+	}
+}
+
+func TestWithAuthenticatedUser(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/user":
+			fmt.Fprint(w, `{"login":"app-bot","name":"App Bot"}`)
+		case "/orgs/test/members":
+			fmt.Fprint(w, `[{"login":"test1"}]`)
+		case "/users/test1":
+			fmt.Fprint(w, `{"login":"test1"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cases := map[string]struct {
+		Opts     []Option
+		Expected []string
+	}{
+		"TestDefault": {Expected: []string{"test1"}},
+		"TestAdded":   {Opts: []Option{WithAuthenticatedUser()}, Expected: []string{"app-bot", "test1"}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+			g.Org = "test"
+			g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+			g.UsersService = g.ghClient.Users
+			for _, opt := range c.Opts {
+				opt(&g.opts)
+			}
+
+			members, _, _, err := g.getMembers(context.Background())
+			if err != nil {
+				t.Fatalf("Name: %s, unexpected error: %v", name, err)
+			}
+			checkLogins(t, name, members, c.Expected)
+		})
+	}
+}
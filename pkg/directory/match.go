@@ -0,0 +1,293 @@
+package directory
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// MatchFields selects which member fields are searched
+type MatchFields int
+
+const (
+	// MatchLogins searches member logins
+	MatchLogins MatchFields = 1 << iota
+	// MatchNames searches member display names
+	MatchNames
+	// MatchEmails searches members' public emails. It is never searched unless asked for, since some
+	// orgs don't want emails to be discoverable.
+	MatchEmails
+	// MatchSAMLNameIDs searches members' SAML NameIDs, which are only known with WithSAMLIdentities
+	MatchSAMLNameIDs
+)
+
+// MatchOptions changes how GetMatchesWithOptions searches. The zero value searches the same way as
+// GetMatches.
+type MatchOptions struct {
+	// Fields are the member fields searched, logins, names and SAML NameIDs when zero. Searching emails
+	// as well needs MatchLogins|MatchNames|MatchSAMLNameIDs|MatchEmails. Teams are always searched by name
+	// and slug.
+	Fields MatchFields
+	// MemberTeams also returns the teams of matching members, not only teams with a matching name
+	MemberTeams bool
+	// CaseSensitive compares logins, names and team names without ignoring case
+	CaseSensitive bool
+	// ExcludeEmptyTeams leaves out teams without members. They are otherwise listed after the other teams.
+	ExcludeEmptyTeams bool
+}
+
+// searches reports whether the member field is searched
+func (mo MatchOptions) searches(f MatchFields) bool {
+	if mo.Fields == 0 {
+		return f == MatchLogins || f == MatchNames || f == MatchSAMLNameIDs
+	}
+	return mo.Fields&f != 0
+}
+
+// contains reports whether substr is within s, honoring CaseSensitive
+func (mo MatchOptions) contains(s, substr string) bool {
+	if mo.CaseSensitive {
+		return strings.Contains(s, substr)
+	}
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// equal reports whether a and b are the same, honoring CaseSensitive
+func (mo MatchOptions) equal(a, b string) bool {
+	if mo.CaseSensitive {
+		return a == b
+	}
+	return strings.ToLower(a) == strings.ToLower(b)
+}
+
+// GetMatches will search for a given value as part of a username or team name or slug and return a set of
+// available options for the user. Members are sorted by login and teams by name with empty teams last,
+// and matches equal to the lookup are also listed in ExactMembers and ExactTeams so a caller can pick a
+// single exact match without prompting. Logins left out by WithRecipientBlocklist or
+// WithRecipientAllowlist aren't returned, neither as members nor in teams, and lookups shorter than
+// WithMinQueryLength match nothing.
+func (g *GH) GetMatches(lookup string) Matches {
+	return g.GetMatchesWithOptions(lookup, MatchOptions{})
+}
+
+// GetMatchesWithOptions is like GetMatches but lets the caller change what is searched
+func (g *GH) GetMatchesWithOptions(lookup string, mo MatchOptions) Matches {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	matches := Matches{}
+
+	if lookup == "*" {
+		matches.Members = g.filterRecipients(append([]Member{}, g.Members...))
+		matches.Teams = []Team{}
+		for _, t := range g.Info.Teams {
+			t = g.filterTeamRecipients(t)
+			if !t.Empty || !mo.ExcludeEmptyTeams {
+				matches.Teams = append(matches.Teams, t)
+			}
+		}
+		matches.sort()
+		return matches
+	}
+	if utf8.RuneCountInString(strings.TrimSpace(lookup)) < g.opts.minQueryLength {
+		return matches
+	}
+
+	for _, m := range g.Members {
+		if !g.allowedRecipient(m.Login) {
+			continue
+		}
+		if (mo.searches(MatchLogins) && mo.contains(m.Login, lookup)) ||
+			(mo.searches(MatchNames) && mo.contains(m.Name, lookup)) ||
+			(mo.searches(MatchEmails) && m.Email != "" && mo.contains(m.Email, lookup)) ||
+			(mo.searches(MatchSAMLNameIDs) && m.SAMLNameID != "" && mo.contains(m.SAMLNameID, lookup)) {
+			matches.Members = append(matches.Members, m)
+		}
+		if (mo.searches(MatchLogins) && mo.equal(m.Login, lookup)) ||
+			(mo.searches(MatchNames) && m.Name != "" && mo.equal(m.Name, lookup)) ||
+			(mo.searches(MatchEmails) && m.Email != "" && mo.equal(m.Email, lookup)) ||
+			(mo.searches(MatchSAMLNameIDs) && m.SAMLNameID != "" && mo.equal(m.SAMLNameID, lookup)) {
+			matches.ExactMembers = append(matches.ExactMembers, m)
+		}
+	}
+
+	matched := make(map[string]struct{}, len(matches.Members))
+	if mo.MemberTeams {
+		for _, m := range matches.Members {
+			matched[strings.ToLower(m.Login)] = struct{}{}
+		}
+	}
+
+	for _, t := range g.Info.Teams {
+		t = g.filterTeamRecipients(t)
+		if t.Empty && mo.ExcludeEmptyTeams {
+			continue
+		}
+		if mo.contains(t.Name, lookup) || (t.Slug != "" && mo.contains(t.Slug, lookup)) || hasMember(t, matched) {
+			matches.Teams = append(matches.Teams, t)
+		}
+		if mo.equal(t.Name, lookup) || (t.Slug != "" && mo.equal(t.Slug, lookup)) {
+			matches.ExactTeams = append(matches.ExactTeams, t)
+		}
+	}
+	matches.sort()
+	return matches
+}
+
+// hasMember reports whether any member of the team is in the set of lowercase logins
+func hasMember(t Team, logins map[string]struct{}) bool {
+	if len(logins) == 0 {
+		return false
+	}
+	for _, m := range t.Members {
+		if _, ok := logins[strings.ToLower(m)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Autocomplete returns up to n logins and team names completing prefix. Prefix matches on logins come
+// first, then on names, then on team names, followed by any remaining substring matches.
+func (g *GH) Autocomplete(prefix string, n int) []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if n <= 0 {
+		return []string{}
+	}
+	lookup := strings.ToLower(prefix)
+
+	// Each tier is sorted on its own so more relevant kinds of matches stay on top
+	tiers := make([][]string, 4)
+	seen := make(map[string]struct{})
+	add := func(tier int, s string) {
+		if _, ok := seen[s]; ok {
+			return
+		}
+		seen[s] = struct{}{}
+		tiers[tier] = append(tiers[tier], s)
+	}
+
+	for _, m := range g.Members {
+		if strings.HasPrefix(strings.ToLower(m.Login), lookup) {
+			add(0, m.Login)
+		}
+	}
+	for _, m := range g.Members {
+		if namePrefix(m.Name, lookup) {
+			add(1, m.Login)
+		}
+	}
+	for _, t := range g.Info.Teams {
+		if strings.HasPrefix(strings.ToLower(t.Name), lookup) {
+			add(2, t.Name)
+		}
+	}
+	for _, m := range g.Members {
+		if strings.Contains(strings.ToLower(m.Login), lookup) || strings.Contains(strings.ToLower(m.Name), lookup) {
+			add(3, m.Login)
+		}
+	}
+	for _, t := range g.Info.Teams {
+		if strings.Contains(strings.ToLower(t.Name), lookup) {
+			add(3, t.Name)
+		}
+	}
+
+	suggestions := []string{}
+	for _, tier := range tiers {
+		sort.Strings(tier)
+		for _, s := range tier {
+			if len(suggestions) == n {
+				return suggestions
+			}
+			suggestions = append(suggestions, s)
+		}
+	}
+	return suggestions
+}
+
+// namePrefix reports whether the name or any word in it starts with the lowercase prefix
+func namePrefix(name, prefix string) bool {
+	name = strings.ToLower(name)
+	if name == "" {
+		return false
+	}
+	if strings.HasPrefix(name, prefix) {
+		return true
+	}
+	for _, word := range strings.Fields(name) {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestDistance is the most edits a login may be away from the lookup to be suggested
+const suggestDistance = 2
+
+// SuggestMember returns the members whose logins are closest to login by edit distance, for "did you
+// mean" hints after IsMember fails. The closest come first, then by login. A login that is a member
+// returns just that member, and nothing is returned when no login is within a couple of edits.
+func (g *GH) SuggestMember(login string) []Member {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if m, ok := g.findMember(login); ok {
+		return []Member{m}
+	}
+
+	lookup := strings.ToLower(login)
+	distances := make(map[string]int)
+	suggestions := []Member{}
+	for _, m := range g.Members {
+		d := editDistance(lookup, strings.ToLower(m.Login))
+		if d <= suggestDistance && d < len(lookup) {
+			distances[m.Login] = d
+			suggestions = append(suggestions, m)
+		}
+	}
+
+	ByMembers(func(m1, m2 *Member) bool {
+		if distances[m1.Login] != distances[m2.Login] {
+			return distances[m1.Login] < distances[m2.Login]
+		}
+		return m1.Login < m2.Login
+	}).Sort(suggestions)
+	return suggestions
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
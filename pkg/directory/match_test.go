@@ -0,0 +1,500 @@
+package directory
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetMatches(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.Members = []Member{Member{Login: "test1", Name: "Test 1"}, Member{Login: "test2", Name: ""}}
+	testGHState.Info.Teams = []Team{Team{Name: "team1", Members: []string{"test1", "test2"}}, Team{Name: "team2", Members: []string{}}}
+
+	cases := map[string]struct {
+		State    *GH
+		Lookup   string
+		Expected Matches
+	}{
+		"NoLookupTest": {
+			State:  testGHState,
+			Lookup: "",
+			Expected: Matches{
+				Members: []Member{Member{Login: "test1", Name: "Test 1"}, Member{Login: "test2", Name: ""}},
+				Teams:   []Team{Team{Name: "team1", Members: []string{"test1", "test2"}}, Team{Name: "team2", Members: []string{}}},
+			},
+		},
+		"StarLookupTest": {
+			State:  testGHState,
+			Lookup: "*",
+			Expected: Matches{
+				Members: []Member{Member{Login: "test1", Name: "Test 1"}, Member{Login: "test2", Name: ""}},
+				Teams:   []Team{Team{Name: "team1", Members: []string{"test1", "test2"}}, Team{Name: "team2", Members: []string{}}},
+			},
+		},
+		"LookupUserLoginTest": {
+			State:  testGHState,
+			Lookup: "test1",
+			Expected: Matches{
+				Members: []Member{Member{Login: "test1", Name: "Test 1"}},
+			},
+		},
+		"LookupUserNameTest": {
+			State:  testGHState,
+			Lookup: "Test 1",
+			Expected: Matches{
+				Members: []Member{Member{Login: "test1", Name: "Test 1"}},
+			},
+		},
+		"LookupUserPartialTest": {
+			State:  testGHState,
+			Lookup: "test",
+			Expected: Matches{
+				Members: []Member{Member{Login: "test1", Name: "Test 1"}, Member{Login: "test2", Name: ""}},
+			},
+		},
+		"LookupTeamNameTest": {
+			State:  testGHState,
+			Lookup: "team1",
+			Expected: Matches{
+				Teams: []Team{Team{Name: "team1", Members: []string{"test1", "test2"}}},
+			},
+		},
+		"LookupTeamPartialTest": {
+			State:  testGHState,
+			Lookup: "team",
+			Expected: Matches{
+				Teams: []Team{Team{Name: "team1", Members: []string{"test1", "test2"}}, Team{Name: "team2", Members: []string{}}},
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := c.State.GetMatches(c.Lookup)
+			t.Logf("Name: %s, got: %+v, expected: %+v", name, got, c.Expected)
+			if !checkMembers(got.Members, c.Expected.Members) {
+				t.Errorf("Name: %s members, got: %+v, expected %+v", name, got, c.Expected)
+			}
+			if !checkTeams(got.Teams, c.Expected.Teams) {
+				t.Errorf("Name: %s teams, got: %+v, expected %+v", name, got, c.Expected)
+			}
+		})
+	}
+}
+
+func TestGetMatchesOrder(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.Members = []Member{Member{Login: "test2"}, Member{Login: "btest"}, Member{Login: "test1"}}
+	testGHState.Info.Teams = []Team{Team{Name: "team2"}, Team{Name: "ateam"}, Team{Name: "team1"}}
+
+	for _, lookup := range []string{"*", "t"} {
+		got := testGHState.GetMatches(lookup)
+		logins := []string{}
+		for _, m := range got.Members {
+			logins = append(logins, m.Login)
+		}
+		names := []string{}
+		for _, t := range got.Teams {
+			names = append(names, t.Name)
+		}
+		if strings.Join(logins, ",") != "btest,test1,test2" {
+			t.Errorf("Lookup: %s, got members: %v", lookup, logins)
+		}
+		if strings.Join(names, ",") != "ateam,team1,team2" {
+			t.Errorf("Lookup: %s, got teams: %v", lookup, names)
+		}
+	}
+	if testGHState.Members[0].Login != "test2" {
+		t.Errorf("expected the cached members to be left in place, got: %+v", testGHState.Members)
+	}
+}
+
+func TestAutocomplete(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.Members = []Member{Member{Login: "dtaylor", Name: "David Taylor"}, Member{Login: "ahamilton", Name: "Andrew Hamilton"}, Member{Login: "test2", Name: "Dana Test"}}
+	testGHState.Info.Teams = []Team{Team{Name: "devops", Members: []string{"dtaylor"}}, Team{Name: "sre", Members: []string{}}}
+
+	cases := map[string]struct {
+		State    *GH
+		Prefix   string
+		N        int
+		Expected []string
+	}{
+		"TestLoginNameTeamOrder": {
+			State:    testGHState,
+			Prefix:   "d",
+			N:        10,
+			Expected: []string{"dtaylor", "test2", "devops", "ahamilton"},
+		},
+		"TestLimit": {
+			State:    testGHState,
+			Prefix:   "d",
+			N:        2,
+			Expected: []string{"dtaylor", "test2"},
+		},
+		"TestNameWord": {
+			State:    testGHState,
+			Prefix:   "ham",
+			N:        10,
+			Expected: []string{"ahamilton"},
+		},
+		"TestZero": {
+			State:    testGHState,
+			Prefix:   "d",
+			N:        0,
+			Expected: []string{},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := c.State.Autocomplete(c.Prefix, c.N)
+			if len(got) != len(c.Expected) {
+				t.Fatalf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+			}
+			for i := range got {
+				if got[i] != c.Expected[i] {
+					t.Errorf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+				}
+			}
+		})
+	}
+}
+
+func TestGetMatchesMemberTeams(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.Members = []Member{Member{Login: "dtaylor", Name: "David Taylor"}, Member{Login: "test2", Name: ""}}
+	testGHState.Info.Teams = []Team{Team{Name: "team1", Members: []string{"dtaylor", "test2"}}, Team{Name: "team2", Members: []string{"test2"}}, Team{Name: "david-fans", Members: []string{}}}
+
+	cases := map[string]struct {
+		Options  MatchOptions
+		Expected []Team
+	}{
+		"TestDefault": {
+			Options:  MatchOptions{},
+			Expected: []Team{Team{Name: "david-fans"}},
+		},
+		"TestMemberTeams": {
+			Options:  MatchOptions{MemberTeams: true},
+			Expected: []Team{Team{Name: "david-fans"}, Team{Name: "team1"}},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := testGHState.GetMatchesWithOptions("david", c.Options)
+			if !checkMembers(got.Members, []Member{Member{Login: "dtaylor", Name: "David Taylor"}}) {
+				t.Errorf("Name: %s members, got: %+v", name, got.Members)
+			}
+			if !checkTeams(got.Teams, c.Expected) {
+				t.Errorf("Name: %s teams, got: %+v, expected %+v", name, got.Teams, c.Expected)
+			}
+		})
+	}
+}
+
+func TestCaseSensitiveMatching(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.Members = []Member{Member{Login: "DTaylor", Name: "David Taylor"}, Member{Login: "test2", Name: "dave"}}
+	testGHState.Info.Teams = []Team{Team{Name: "Devs"}}
+
+	got := testGHState.GetMatchesWithOptions("Dav", MatchOptions{CaseSensitive: true})
+	if !checkMembers(got.Members, []Member{Member{Login: "DTaylor", Name: "David Taylor"}}) {
+		t.Errorf("got: %+v, expected only DTaylor", got.Members)
+	}
+	got = testGHState.GetMatchesWithOptions("dev", MatchOptions{CaseSensitive: true})
+	if len(got.Teams) != 0 {
+		t.Errorf("got: %+v, expected no teams", got.Teams)
+	}
+
+	if _, ok := testGHState.IsMemberWithOptions("dtaylor", MatchOptions{CaseSensitive: true}); ok {
+		t.Errorf("expected a case sensitive lookup of dtaylor to fail")
+	}
+	if login, ok := testGHState.IsMemberWithOptions("DTaylor", MatchOptions{CaseSensitive: true}); !ok || login != "DTaylor" {
+		t.Errorf("got: %s, %v, expected DTaylor to match", login, ok)
+	}
+	if _, ok := testGHState.IsMember("dtaylor"); !ok {
+		t.Errorf("expected the default lookup to ignore case")
+	}
+}
+
+func TestEmptyTeams(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.setInfo(Info{Teams: []Team{
+		Team{Name: "ateam"},
+		Team{Name: "bteam", Members: []string{"test1"}},
+		Team{Name: "cteam", Members: []string{"test2"}},
+	}})
+
+	if !testGHState.Info.Teams[0].Empty || testGHState.Info.Teams[1].Empty {
+		t.Fatalf("got: %+v, expected only ateam to be empty", testGHState.Info.Teams)
+	}
+
+	cases := map[string]struct {
+		Lookup   string
+		Options  MatchOptions
+		Expected []string
+	}{
+		"TestDemoted":      {Lookup: "team", Expected: []string{"bteam", "cteam", "ateam"}},
+		"TestAllDemoted":   {Lookup: "*", Expected: []string{"bteam", "cteam", "ateam"}},
+		"TestExcluded":     {Lookup: "team", Options: MatchOptions{ExcludeEmptyTeams: true}, Expected: []string{"bteam", "cteam"}},
+		"TestAllExcluded":  {Lookup: "*", Options: MatchOptions{ExcludeEmptyTeams: true}, Expected: []string{"bteam", "cteam"}},
+		"TestOnlyEmpty":    {Lookup: "ateam", Expected: []string{"ateam"}},
+		"TestOnlyExcluded": {Lookup: "ateam", Options: MatchOptions{ExcludeEmptyTeams: true}, Expected: []string{}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := testGHState.GetMatchesWithOptions(c.Lookup, c.Options)
+			if len(got.Teams) != len(c.Expected) {
+				t.Fatalf("Name: %s, got: %+v, expected: %v", name, got.Teams, c.Expected)
+			}
+			for i := range got.Teams {
+				if got.Teams[i].Name != c.Expected[i] {
+					t.Errorf("Name: %s, got: %+v, expected: %v", name, got.Teams, c.Expected)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchTeamSlugs(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.setInfo(Info{Teams: []Team{
+		Team{Name: "Site Reliability", Slug: "sre-core", Members: []string{"test1"}},
+		Team{Name: "Platform", Slug: "platform", Members: []string{"test2"}},
+		Team{Name: "legacy", Members: []string{"test3"}},
+	}})
+
+	cases := map[string]struct {
+		Lookup   string
+		Options  MatchOptions
+		Expected []string
+	}{
+		"TestSlug":          {Lookup: "sre-", Expected: []string{"Site Reliability"}},
+		"TestName":          {Lookup: "reliab", Expected: []string{"Site Reliability"}},
+		"TestSlugCase":      {Lookup: "SRE", Expected: []string{"Site Reliability"}},
+		"TestCaseSensitive": {Lookup: "SRE", Options: MatchOptions{CaseSensitive: true}, Expected: []string{}},
+		"TestNoSlug":        {Lookup: "legacy", Expected: []string{"legacy"}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := testGHState.GetMatchesWithOptions(c.Lookup, c.Options)
+			if len(got.Teams) != len(c.Expected) {
+				t.Fatalf("Name: %s, got: %+v, expected: %v", name, got.Teams, c.Expected)
+			}
+			for i := range got.Teams {
+				if got.Teams[i].Name != c.Expected[i] {
+					t.Errorf("Name: %s, got: %+v, expected: %v", name, got.Teams, c.Expected)
+				}
+			}
+		})
+	}
+}
+
+func TestGetMatchesExact(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.setInfo(Info{
+		Members: []Member{Member{Login: "test", Name: "Tester"}, Member{Login: "test1", Name: "Test"}, Member{Login: "test2"}},
+		Teams: []Team{
+			Team{Name: "Test", Slug: "test-team", Members: []string{"test1"}},
+			Team{Name: "test-team-2", Slug: "test-team-2"},
+			Team{Name: "Core", Slug: "core"},
+		},
+	})
+
+	cases := map[string]struct {
+		Lookup       string
+		Options      MatchOptions
+		ExactMembers []string
+		ExactTeams   []string
+		Partial      []string
+	}{
+		"TestLoginAndName": {Lookup: "TEST", ExactMembers: []string{"test", "test1"}, ExactTeams: []string{"Test"}, Partial: []string{"test2"}},
+		"TestSlug":         {Lookup: "test-team", ExactTeams: []string{"Test"}},
+		"TestNoneExact":    {Lookup: "tes", Partial: []string{"test", "test1", "test2"}},
+		"TestCaseSensitive": {
+			Lookup:       "test",
+			Options:      MatchOptions{CaseSensitive: true},
+			ExactMembers: []string{"test"},
+			Partial:      []string{"test1", "test2"},
+		},
+		"TestStar": {Lookup: "*", Partial: []string{"test", "test1", "test2"}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := testGHState.GetMatchesWithOptions(c.Lookup, c.Options)
+			checkLogins(t, name, got.ExactMembers, c.ExactMembers)
+			if len(got.ExactTeams) != len(c.ExactTeams) {
+				t.Fatalf("Name: %s, got: %+v, expected: %v", name, got.ExactTeams, c.ExactTeams)
+			}
+			for i := range got.ExactTeams {
+				if got.ExactTeams[i].Name != c.ExactTeams[i] {
+					t.Errorf("Name: %s, got: %+v, expected: %v", name, got.ExactTeams, c.ExactTeams)
+				}
+			}
+			checkLogins(t, name, got.Partial().Members, c.Partial)
+			if len(got.Partial().Teams)+len(got.ExactTeams) != len(got.Teams) {
+				t.Errorf("Name: %s, got: %+v, expected the partial and exact teams to add up", name, got)
+			}
+		})
+	}
+}
+
+func TestMinQueryLength(t *testing.T) {
+	testGHState := &GH{}
+	WithMinQueryLength(2)(&testGHState.opts)
+	testGHState.setInfo(Info{
+		Members: []Member{Member{Login: "test1"}, Member{Login: "étienne"}},
+		Teams:   []Team{Team{Name: "team1", Members: []string{"test1"}}},
+	})
+
+	cases := map[string]struct {
+		Lookup  string
+		Members int
+		Teams   int
+	}{
+		"TestTooShort":    {Lookup: "t"},
+		"TestSpaces":      {Lookup: " t "},
+		"TestLongEnough":  {Lookup: "te", Members: 1, Teams: 1},
+		"TestMultibyte":   {Lookup: "ét", Members: 1},
+		"TestEverything":  {Lookup: "*", Members: 2, Teams: 1},
+		"TestEmptyLookup": {Lookup: ""},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := testGHState.GetMatches(c.Lookup)
+			if len(got.Members) != c.Members || len(got.Teams) != c.Teams {
+				t.Errorf("Name: %s, got: %+v, expected %d members and %d teams", name, got, c.Members, c.Teams)
+			}
+		})
+	}
+}
+
+func TestSuggestMember(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.Members = []Member{Member{Login: "dtaylor"}, Member{Login: "dthomas"}, Member{Login: "dtaylr"}, Member{Login: "ahamilton"}}
+
+	cases := map[string]struct {
+		Lookup   string
+		Expected []string
+	}{
+		"TestTypo": {
+			Lookup:   "dtalyor",
+			Expected: []string{"dtaylor", "dtaylr"},
+		},
+		"TestClosestFirst": {
+			Lookup:   "dtaylorr",
+			Expected: []string{"dtaylor", "dtaylr"},
+		},
+		"TestExact": {
+			Lookup:   "DThomas",
+			Expected: []string{"dthomas"},
+		},
+		"TestNothingClose": {
+			Lookup:   "someoneelse",
+			Expected: []string{},
+		},
+		"TestShortLookup": {
+			Lookup:   "d",
+			Expected: []string{},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			checkLogins(t, name, testGHState.SuggestMember(c.Lookup), c.Expected)
+		})
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	cases := map[string]struct {
+		A, B     string
+		Expected int
+	}{
+		"TestSame":      {A: "dtaylor", B: "dtaylor", Expected: 0},
+		"TestEmpty":     {A: "", B: "abc", Expected: 3},
+		"TestSwap":      {A: "dtalyor", B: "dtaylor", Expected: 2},
+		"TestKitten":    {A: "kitten", B: "sitting", Expected: 3},
+		"TestMultibyte": {A: "josé", B: "jose", Expected: 1},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := editDistance(c.A, c.B); got != c.Expected {
+				t.Errorf("Name: %s, got: %d, expected: %d", name, got, c.Expected)
+			}
+		})
+	}
+}
+
+func TestMatchFields(t *testing.T) {
+	testGHState := &GH{}
+	testGHState.Members = []Member{Member{Login: "dtaylor", Name: "David Taylor", Email: "david@example.com"}, Member{Login: "davidt", Name: "Someone Else", SAMLNameID: "selse"}}
+
+	cases := map[string]struct {
+		Fields   MatchFields
+		Lookup   string
+		Expected []Member
+	}{
+		"TestBoth": {
+			Lookup:   "tay",
+			Expected: []Member{Member{Login: "dtaylor", Name: "David Taylor"}},
+		},
+		"TestBothLoginAndName": {
+			Lookup:   "david",
+			Expected: []Member{Member{Login: "dtaylor", Name: "David Taylor"}, Member{Login: "davidt", Name: "Someone Else"}},
+		},
+		"TestLoginsOnly": {
+			Fields:   MatchLogins,
+			Lookup:   "david",
+			Expected: []Member{Member{Login: "davidt", Name: "Someone Else"}},
+		},
+		"TestNamesOnly": {
+			Fields:   MatchNames,
+			Lookup:   "david",
+			Expected: []Member{Member{Login: "dtaylor", Name: "David Taylor"}},
+		},
+		"TestExplicitBoth": {
+			Fields:   MatchLogins | MatchNames,
+			Lookup:   "else",
+			Expected: []Member{Member{Login: "davidt", Name: "Someone Else"}},
+		},
+		"TestEmailNotByDefault": {
+			Lookup:   "@example.com",
+			Expected: []Member{},
+		},
+		"TestEmailDomain": {
+			Fields:   MatchLogins | MatchNames | MatchEmails,
+			Lookup:   "@EXAMPLE.com",
+			Expected: []Member{Member{Login: "dtaylor", Name: "David Taylor"}},
+		},
+		"TestEmailLocalPart": {
+			Fields:   MatchEmails,
+			Lookup:   "david@",
+			Expected: []Member{Member{Login: "dtaylor", Name: "David Taylor"}},
+		},
+		"TestSAMLNameIDByDefault": {
+			Lookup:   "SELSE",
+			Expected: []Member{Member{Login: "davidt", Name: "Someone Else"}},
+		},
+		"TestSAMLNameIDNotSelected": {
+			Fields:   MatchLogins | MatchNames,
+			Lookup:   "selse",
+			Expected: []Member{},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := testGHState.GetMatchesWithOptions(c.Lookup, MatchOptions{Fields: c.Fields})
+			if !checkMembers(got.Members, c.Expected) {
+				t.Errorf("Name: %s, got: %+v, expected: %+v", name, got.Members, c.Expected)
+			}
+		})
+	}
+}
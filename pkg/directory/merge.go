@@ -0,0 +1,95 @@
+package directory
+
+import (
+	"strings"
+)
+
+// MergeDirectories combines the members and teams of several directories, such as clients loaded from
+// the caches of different orgs, into one for searching. Members with the same login are merged into
+// one, keeping the details from the first directory, and Member.Orgs lists every org they were found
+// in. Teams keep Team.Org, and a team whose slug is used in more than one org can be looked up as
+// "org/slug".
+//
+// The merged directory has no org or GitHub client of its own, so only methods that read the
+// members and teams should be used on it.
+func MergeDirectories(dirs ...*GH) *GH {
+	info := Info{ActiveMemberTeams: []string{}, Members: []Member{}, Teams: []Team{}}
+	members := make(map[string]int)
+	teams := make(map[string]struct{})
+	activeTeams := make(map[string]struct{})
+
+	for _, dir := range dirs {
+		dir.mu.RLock()
+		for _, m := range dir.Members {
+			orgs := originOrgs(m.Orgs, dir.Org)
+			login := strings.ToLower(m.Login)
+			if i, ok := members[login]; ok {
+				info.Members[i].Orgs = appendOrgs(info.Members[i].Orgs, orgs)
+				continue
+			}
+			m.Orgs = appendOrgs(nil, orgs)
+			members[login] = len(info.Members)
+			info.Members = append(info.Members, m)
+		}
+
+		for _, t := range dir.Info.Teams {
+			if t.Org == "" {
+				t.Org = dir.Org
+			}
+			key := t.Slug
+			if key == "" {
+				key = t.Name
+			}
+			key = strings.ToLower(t.Org + "/" + key)
+			if _, ok := teams[key]; ok {
+				continue
+			}
+			teams[key] = struct{}{}
+			info.Teams = append(info.Teams, t)
+		}
+
+		for _, name := range dir.ActiveMemberTeams {
+			if _, ok := activeTeams[name]; !ok {
+				activeTeams[name] = struct{}{}
+				info.ActiveMemberTeams = append(info.ActiveMemberTeams, name)
+			}
+		}
+		dir.mu.RUnlock()
+	}
+
+	ByMembers(sortMemberLogins).Sort(info.Members)
+	ByTeams(sortTeamNames).Sort(info.Teams)
+
+	merged := &GH{opts: defaultOptions()}
+	merged.setInfo(info)
+	return merged
+}
+
+// originOrgs returns the orgs a member came from, which is the directory's org unless the directory was
+// itself merged
+func originOrgs(orgs []string, org string) []string {
+	if len(orgs) > 0 {
+		return orgs
+	}
+	if org == "" {
+		return nil
+	}
+	return []string{org}
+}
+
+// appendOrgs adds the orgs not already in dst
+func appendOrgs(dst, orgs []string) []string {
+	for _, org := range orgs {
+		found := false
+		for _, existing := range dst {
+			if strings.EqualFold(existing, org) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst = append(dst, org)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,66 @@
+package directory
+
+import (
+	"testing"
+)
+
+func TestMergeDirectories(t *testing.T) {
+	org1 := &GH{}
+	org1.Org = "org1"
+	org1.Members = []Member{Member{Login: "test1", Name: "Test 1"}, Member{Login: "test2", Name: "Test 2"}}
+	org1.Info.Teams = []Team{Team{Name: "platform", Slug: "platform", Members: []string{"test1"}}}
+	org1.ActiveMemberTeams = []string{"platform"}
+
+	org2 := &GH{}
+	org2.Org = "org2"
+	org2.Members = []Member{Member{Login: "TEST2", Name: "Other Name"}, Member{Login: "test3", Name: "Test 3"}}
+	org2.Info.Teams = []Team{
+		Team{Name: "platform", Slug: "platform", Members: []string{"test3"}},
+		Team{Name: "data", Slug: "data", Members: []string{"test2"}},
+	}
+	org2.ActiveMemberTeams = []string{"platform", "data"}
+
+	// Merging the same directory twice doesn't duplicate anything
+	g := MergeDirectories(org1, org2, org1)
+
+	checkLogins(t, "TestMergeDirectories", g.GetMembers(), []string{"test1", "test2", "test3"})
+	expectedOrgs := map[string][]string{"test1": {"org1"}, "test2": {"org1", "org2"}, "test3": {"org2"}}
+	for _, m := range g.GetMembers() {
+		if len(m.Orgs) != len(expectedOrgs[m.Login]) {
+			t.Fatalf("got: %+v, expected orgs: %v", m, expectedOrgs[m.Login])
+		}
+		for i := range m.Orgs {
+			if m.Orgs[i] != expectedOrgs[m.Login][i] {
+				t.Errorf("got: %+v, expected orgs: %v", m, expectedOrgs[m.Login])
+			}
+		}
+	}
+	if m := g.GetMembers()[1]; m.Name != "Test 2" {
+		t.Errorf("got: %+v, expected the first directory's details", m)
+	}
+
+	if got := g.GetTeamsByName("platform"); len(got) != 2 || got[0].Org == got[1].Org {
+		t.Errorf("got: %+v, expected a platform team from each org", got)
+	}
+	if got := g.GetTeamMembers("org2/platform"); len(got) != 1 || got[0] != "test3" {
+		t.Errorf("got: %v, expected the platform team from org2", got)
+	}
+	if got := g.GetTeamMembers("data"); len(got) != 1 || got[0] != "test2" {
+		t.Errorf("got: %v, expected the data team from org2", got)
+	}
+	if got := g.GetMemberTeams("test2"); len(got) != 1 {
+		t.Errorf("got: %v, expected test2 to be in one team", got)
+	}
+	if len(g.ActiveMemberTeams) != 2 {
+		t.Errorf("got: %v, expected the active member teams without duplicates", g.ActiveMemberTeams)
+	}
+
+	// Merging a merged directory keeps the original orgs
+	again := MergeDirectories(g)
+	for _, team := range again.GetTeams() {
+		if team.Org == "" {
+			t.Errorf("got: %+v, expected the team to keep its org", team)
+		}
+	}
+	checkLogins(t, "TestMergeMerged", again.GetMembers(), []string{"test1", "test2", "test3"})
+}
@@ -0,0 +1,40 @@
+package directory
+
+import (
+	"time"
+)
+
+// Events reported to a Collector
+const (
+	// MetricCacheHit is counted when members and teams are loaded from the cache
+	MetricCacheHit = "cache_hit"
+	// MetricCacheMiss is counted when the cache was missing, stale or unreadable and GitHub was used
+	MetricCacheMiss = "cache_miss"
+	// MetricAPICall is counted for every request made to the GitHub API. The scope names the endpoint.
+	MetricAPICall = "api_call"
+	// MetricFetchDuration is how long fetching from GitHub took. The scope is "members", "teams" or
+	// "all" for the whole refresh.
+	MetricFetchDuration = "fetch_duration"
+)
+
+// Collector receives metrics about cache use and GitHub API calls, such as for exporting to Prometheus.
+// It is called from several goroutines at once so it must be safe for concurrent use.
+type Collector interface {
+	// Count adds n to the counter for the event
+	Count(event, scope string, n int)
+	// Duration records how long the event took
+	Duration(event, scope string, d time.Duration)
+}
+
+func (g *GH) count(event, scope string) {
+	if g.opts.metrics != nil {
+		g.opts.metrics.Count(event, scope, 1)
+	}
+}
+
+// since records the time elapsed from start as a MetricFetchDuration, meant to be deferred
+func (g *GH) since(scope string, start time.Time) {
+	if g.opts.metrics != nil {
+		g.opts.metrics.Duration(MetricFetchDuration, scope, time.Since(start))
+	}
+}
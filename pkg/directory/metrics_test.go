@@ -0,0 +1,76 @@
+package directory
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+type testCollector struct {
+	mu        sync.Mutex
+	counts    map[string]int
+	durations map[string]int
+}
+
+func (c *testCollector) Count(event, scope string, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[event+"/"+scope] += n
+}
+
+func (c *testCollector) Duration(event, scope string, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.durations[event+"/"+scope]++
+}
+
+func TestMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(orig string) { cacheDir = orig }(cacheDir)
+	cacheDir = dir
+
+	c := &testCollector{counts: map[string]int{}, durations: map[string]int{}}
+	g := &GH{Info: Info{Org: "test"}}
+	WithMetrics(c)(&g.opts)
+	WithRetryPolicy(RetryPolicy{MaxAttempts: 3})(&g.opts)
+
+	if err := os.MkdirAll(g.orgCacheDir(), 0700); err != nil {
+		t.Fatalf("unable to create cache dir: %v", err)
+	}
+	for _, file := range []string{membersCacheFile, teamsCacheFile, activeMembershipsCacheFile} {
+		if err := g.saveCache(g.cacheFile(file), []string{}); err != nil {
+			t.Fatalf("unable to save %s: %v", file, err)
+		}
+	}
+	if _, err := g.getMembersAndTeams(context.Background(), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	calls := 0
+	g.listPage(context.Background(), "list_teams", func(context.Context) (*github.Response, error) {
+		calls++
+		if calls == 1 {
+			return nil, context.DeadlineExceeded
+		}
+		return &github.Response{}, nil
+	})
+
+	expected := map[string]int{"cache_hit/all": 1, "api_call/list_teams": 2}
+	for k, v := range expected {
+		if c.counts[k] != v {
+			t.Errorf("got %d for %s, expected %d: %v", c.counts[k], k, v, c.counts)
+		}
+	}
+	if c.counts["cache_miss/all"] != 0 || len(c.durations) != 0 {
+		t.Errorf("got: %v %v, expected no misses or fetches", c.counts, c.durations)
+	}
+}
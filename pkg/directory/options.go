@@ -0,0 +1,467 @@
+package directory
+
+import (
+	"strings"
+	"time"
+)
+
+// Option configures optional behavior of the GitHub directory
+type Option func(*options)
+
+// Logger receives reports of problems that don't stop the directory from working, such as a failed
+// background refresh. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// CacheFormat selects how the cache is serialized
+type CacheFormat int
+
+const (
+	// CacheFormatJSON stores the cache as JSON, which is easy to inspect
+	CacheFormatJSON CacheFormat = iota
+	// CacheFormatGob stores the cache with encoding/gob, which is faster to load for large orgs
+	CacheFormatGob
+	// CacheFormatJSONLines stores one JSON value per line. Unencrypted caches are written and can be read
+	// back an item at a time, which keeps memory use down for very large orgs.
+	CacheFormatJSONLines
+)
+
+// MemberSort selects the order of the members list
+type MemberSort int
+
+const (
+	// MemberSortLogin orders members by login
+	MemberSortLogin MemberSort = iota
+	// MemberSortName orders members by display name ignoring case. Members without a name come last,
+	// ordered by login.
+	MemberSortName
+)
+
+// MemberFilter narrows down which organization members are fetched, mirroring the filters of GitHub's
+// list members API. The zero value lists every member.
+type MemberFilter struct {
+	// Role is "admin" for org owners or "member" for everyone else. Empty lists both.
+	Role string
+	// TwoFactorDisabled only lists members without two-factor authentication. It takes an org owner's
+	// token.
+	TwoFactorDisabled bool
+	// PublicOnly only lists members who made their membership public
+	PublicOnly bool
+}
+
+// filter is the list members API's filter parameter
+func (f MemberFilter) filter() string {
+	if f.TwoFactorDisabled {
+		return "2fa_disabled"
+	}
+	return ""
+}
+
+// cacheKey describes the filter for cache paths, empty for the zero value so unfiltered caches stay
+// where they were
+func (f MemberFilter) cacheKey() string {
+	parts := []string{}
+	if f.Role != "" && f.Role != "all" {
+		parts = append(parts, "role="+f.Role)
+	}
+	if f.TwoFactorDisabled {
+		parts = append(parts, "2fa_disabled")
+	}
+	if f.PublicOnly {
+		parts = append(parts, "public")
+	}
+	return strings.Join(parts, ",")
+}
+
+type options struct {
+	workers        int
+	perPage        int
+	cacheKey       []byte
+	verboseErrors  bool
+	pendingMembers bool
+	logger         Logger
+	timeout        time.Duration
+	cacheFormat    CacheFormat
+	teamRepos      bool
+	cacheTTL       time.Duration
+	cacheTTLSet    bool
+	metrics        Collector
+	forceRefresh   bool
+	adaptive       bool
+	cacheDir       string
+	teamTimes      bool
+	maxMembers     int
+	maxTeams       int
+	retryPolicy    RetryPolicy
+	tokenFile      string
+
+	withoutSecretTeams bool
+	teamMaintainers    bool
+	strictTeams        bool
+	samlIdentities     bool
+	token              string
+	baseURL            string
+	teamFilter         string
+	insecureTLS        bool
+	memberSort         MemberSort
+	includeSelf        bool
+	singleFileCache    bool
+	cacheLock          bool
+	minQueryLength     int
+	cacheStore         CacheStore
+	memberFilter       MemberFilter
+	workerJitter       time.Duration
+	memberHook         func(Member) Member
+	// recipientBlocklist and recipientAllowlist hold lowercase logins. A nil allowlist allows everyone.
+	recipientBlocklist map[string]struct{}
+	recipientAllowlist map[string]struct{}
+}
+
+func defaultOptions() options {
+	return options{
+		workers:      ghWorkers,
+		perPage:      ghPerPage,
+		workerJitter: ghWorkerJitter,
+	}
+}
+
+// WithWorkers sets the number of concurrent lookups used when fetching members and teams
+func WithWorkers(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.workers = n
+		}
+	}
+}
+
+// WithWorkerJitter sets the longest each member lookup worker waits, picked at random, before its first
+// request, which spreads out the initial burst that can trip GitHub's secondary rate limits. The
+// default is 100ms and zero starts every worker right away.
+func WithWorkerJitter(d time.Duration) Option {
+	return func(o *options) {
+		o.workerJitter = d
+	}
+}
+
+// WithPerPage sets the page size used for paginated GitHub API calls. GitHub caps this at 100.
+func WithPerPage(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.perPage = n
+		}
+	}
+}
+
+// WithCacheEncryption encrypts the on-disk cache with AES-GCM using the given 16, 24 or 32 byte key.
+// The key may also be provided base64 encoded in the PSST_CACHE_KEY environment variable.
+func WithCacheEncryption(key []byte) Option {
+	return func(o *options) {
+		o.cacheKey = key
+	}
+}
+
+// WithVerboseErrors includes GitHub's error message and documentation link in errors from API calls
+func WithVerboseErrors() Option {
+	return func(o *options) {
+		o.verboseErrors = true
+	}
+}
+
+// WithPendingMembers includes members who were invited to the organization but haven't accepted yet.
+// They are marked with MemberStatePending. Listing invitations requires organization owner access.
+func WithPendingMembers() Option {
+	return func(o *options) {
+		o.pendingMembers = true
+	}
+}
+
+// WithLogger sets where non-fatal problems are reported. They are discarded by default.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithTimeout limits how long fetching all members and teams may take. The budget is shared by every
+// page and lookup, and everything still running is cancelled once it runs out. Without it each page
+// of results is limited to a couple of seconds on its own.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithCacheFormat sets the format new cache files are written in. Existing files in any format can
+// always be read. The default is CacheFormatJSON.
+func WithCacheFormat(f CacheFormat) Option {
+	return func(o *options) {
+		o.cacheFormat = f
+	}
+}
+
+// WithMemberHook calls hook with each member fetched from GitHub and keeps the member it returns, for
+// instance with Annotations added from the caller's own systems. Members are passed to it one at a
+// time as they are fetched, never concurrently, and before they are cached. The hook must not change
+// the login.
+func WithMemberHook(hook func(Member) Member) Option {
+	return func(o *options) {
+		o.memberHook = hook
+	}
+}
+
+// WithMemberFilter only fetches the members matching f, such as just the org owners. Teams are still
+// fetched whole. Each filter gets its own cache so clients with different filters don't overwrite each
+// other's. Since a filter may rightly match nobody, an empty result is cached with one.
+func WithMemberFilter(f MemberFilter) Option {
+	return func(o *options) {
+		o.memberFilter = f
+	}
+}
+
+// WithCacheStore keeps the cache in store instead of files in the cache directory, which then isn't
+// created. See CacheStore for what the store needs to provide.
+func WithCacheStore(store CacheStore) Option {
+	return func(o *options) {
+		o.cacheStore = store
+	}
+}
+
+// WithSingleFileCache keeps the whole directory in one cache file, written in a single atomic step, instead
+// of separate members and teams files. Members and teams read back are then always from the same fetch.
+// The file has the same layout as SaveSnapshot's output. CacheFormatJSONLines is stored as JSON in this
+// mode since the file isn't a list.
+func WithSingleFileCache() Option {
+	return func(o *options) {
+		o.singleFileCache = true
+	}
+}
+
+// WithCacheLock holds an exclusive file lock on the org's cache directory while it is loaded, fetched and
+// saved, so psst processes sharing a cache take turns instead of all fetching at once and overwriting
+// each other's files. Waiting for the lock ignores contexts. It does nothing with WithCacheStore or on
+// platforms without flock, where cache files are still written atomically.
+func WithCacheLock() Option {
+	return func(o *options) {
+		o.cacheLock = true
+	}
+}
+
+// WithMinQueryLength makes GetMatches return nothing for lookups shorter than n characters, ignoring
+// surrounding spaces, so a single keystroke in an autocomplete doesn't list most of a big org. "*" still
+// matches everything.
+func WithMinQueryLength(n int) Option {
+	return func(o *options) {
+		o.minQueryLength = n
+	}
+}
+
+// WithTeamRepos also fetches the repositories each team can access into Team.Repos. It costs at least one
+// more API call per team so it is off by default. Teams loaded from a cache written without it have no
+// repos until the next refresh.
+func WithTeamRepos() Option {
+	return func(o *options) {
+		o.teamRepos = true
+	}
+}
+
+// WithCacheTTL sets how long the cache is used before members and teams are fetched again. A TTL of zero
+// means the cache is never read and everything is fetched from GitHub, though it is still written. The
+// default is an hour.
+func WithCacheTTL(d time.Duration) Option {
+	return func(o *options) {
+		if d >= 0 {
+			o.cacheTTL = d
+			o.cacheTTLSet = true
+		}
+	}
+}
+
+// WithMetrics reports cache hits and misses, API calls and fetch durations to the collector. Nothing is
+// reported by default.
+func WithMetrics(c Collector) Option {
+	return func(o *options) {
+		o.metrics = c
+	}
+}
+
+// WithForceRefresh fetches members and teams from GitHub when the client is created even if the cache
+// is still fresh, like passing updateCache to NewGitHub
+func WithForceRefresh() Option {
+	return func(o *options) {
+		o.forceRefresh = true
+	}
+}
+
+// WithAdaptiveWorkers lowers how many lookups run at once as the token's remaining rate limit runs low,
+// and raises it again as the limit recovers. Without it every worker keeps making requests until the
+// limit is exhausted.
+func WithAdaptiveWorkers() Option {
+	return func(o *options) {
+		o.adaptive = true
+	}
+}
+
+// WithCacheDir stores the cache under dir instead of the PSST_CACHE_DIR environment variable or, when
+// that isn't set either, ~/.psst/cache
+func WithCacheDir(dir string) Option {
+	return func(o *options) {
+		o.cacheDir = dir
+	}
+}
+
+// WithTeamTimes also fetches when each team was created and last updated into Team.CreatedAt and
+// Team.UpdatedAt. GitHub leaves them out of the team list, so this costs another API call per team.
+func WithTeamTimes() Option {
+	return func(o *options) {
+		o.teamTimes = true
+	}
+}
+
+// WithMaxMembers stops fetching after n members. It only exists to speed up development against large
+// orgs and must not be used in production, since the directory will be missing members. Results are
+// never cached while it is set. Zero, the default, fetches everyone.
+func WithMaxMembers(n int) Option {
+	return func(o *options) {
+		if n >= 0 {
+			o.maxMembers = n
+		}
+	}
+}
+
+// WithMaxTeams stops fetching after n teams. Like WithMaxMembers it is only meant for development.
+func WithMaxTeams(n int) Option {
+	return func(o *options) {
+		if n >= 0 {
+			o.maxTeams = n
+		}
+	}
+}
+
+// WithTeamFilter only keeps teams whose slug or name starts with prefix, ignoring case, such as "sre-".
+// The members of other teams are never looked up, which speeds up fetching in orgs with many teams.
+// Like the limits above, a filtered result isn't cached, though a cache written without a filter is
+// still used and filtered when loaded.
+func WithTeamFilter(prefix string) Option {
+	return func(o *options) {
+		o.teamFilter = prefix
+	}
+}
+
+// WithRetryPolicy sets how every GitHub API call is retried after a transient failure. A policy with
+// MaxAttempts of zero keeps DefaultRetryPolicy.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(o *options) {
+		o.retryPolicy = p
+	}
+}
+
+// WithTokenFile reads the GitHub token from a file, such as a mounted Kubernetes secret, instead of the
+// GITHUB_TOKEN environment variable. Surrounding whitespace is ignored and a missing or empty file is an
+// error.
+func WithTokenFile(path string) Option {
+	return func(o *options) {
+		o.tokenFile = path
+	}
+}
+
+// WithoutSecretTeams keeps secret teams out of the on-disk cache and snapshots. They are still fetched
+// and usable until the client goes away, but a run that loads from the cache won't know about them.
+func WithoutSecretTeams() Option {
+	return func(o *options) {
+		o.withoutSecretTeams = true
+	}
+}
+
+// WithTeamMaintainers also fetches which members maintain each team into Team.Maintainers, for
+// GetTeamMaintainers. This costs another API call per team.
+func WithTeamMaintainers() Option {
+	return func(o *options) {
+		o.teamMaintainers = true
+	}
+}
+
+// WithStrictTeams fails the whole fetch when any team's members, maintainers, repositories or details
+// can't be looked up. By default such a team is kept with Team.Incomplete set and a warning logged, and
+// the fetch only fails when every team does.
+func WithStrictTeams() Option {
+	return func(o *options) {
+		o.strictTeams = true
+	}
+}
+
+// WithSAMLIdentities also fetches each member's SAML NameID into Member.SAMLNameID, so members of orgs
+// using SAML single sign-on can be found by their corporate username. The token needs admin:org.
+func WithSAMLIdentities() Option {
+	return func(o *options) {
+		o.samlIdentities = true
+	}
+}
+
+// WithToken sets the GitHub token directly, taking precedence over WithTokenFile and GITHUB_TOKEN
+func WithToken(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
+
+// WithBaseURL sets the GitHub API endpoint, such as https://github.example.com/api/v3/ for an
+// enterprise server, taking precedence over GITHUB_API_URL
+func WithBaseURL(u string) Option {
+	return func(o *options) {
+		o.baseURL = u
+	}
+}
+
+// WithInsecureTLS skips verifying GitHub's TLS certificate, for enterprise servers with self-signed
+// certificates in development. This is dangerous: anyone able to intercept the connection can read the
+// token and feed back a made up directory. Never use it against github.com or in production.
+func WithInsecureTLS() Option {
+	return func(o *options) {
+		o.insecureTLS = true
+	}
+}
+
+// WithMemberSort sets the order of GetMembers and the members cache. The default is MemberSortLogin.
+// Search results and resolved recipients are always ordered by login.
+func WithMemberSort(s MemberSort) Option {
+	return func(o *options) {
+		o.memberSort = s
+	}
+}
+
+// WithAuthenticatedUser makes sure the token's own user is one of the members even when the org's
+// member list leaves them out, such as for some app tokens, so IsMember(Whoami()) holds. It costs one
+// more API call per fetch.
+func WithAuthenticatedUser() Option {
+	return func(o *options) {
+		o.includeSelf = true
+	}
+}
+
+// WithRecipientBlocklist keeps logins, such as service accounts, out of what Resolve, ResolveRecipients
+// and GetMatches return, ignoring case. They are treated as though they weren't members: a token naming
+// one of them doesn't resolve and teams are expanded without them.
+func WithRecipientBlocklist(logins []string) Option {
+	return func(o *options) {
+		o.recipientBlocklist = loginSet(logins)
+	}
+}
+
+// WithRecipientAllowlist limits what Resolve, ResolveRecipients and GetMatches return to logins, ignoring
+// case, the same way WithRecipientBlocklist leaves logins out. An empty allowlist allows nobody. A login
+// on both lists is blocked.
+func WithRecipientAllowlist(logins []string) Option {
+	return func(o *options) {
+		o.recipientAllowlist = loginSet(logins)
+	}
+}
+
+// loginSet returns the logins lowercased, never nil
+func loginSet(logins []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(logins))
+	for _, login := range logins {
+		set[strings.ToLower(strings.TrimSpace(login))] = struct{}{}
+	}
+	return set
+}
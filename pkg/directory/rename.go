@@ -0,0 +1,123 @@
+package directory
+
+import (
+	"strings"
+)
+
+// previousMembers returns the members loaded so far or, before anything is loaded, the ones in the
+// cache however old it is, so renames since the cache was written are still noticed
+func (g *GH) previousMembers() []Member {
+	if members := g.GetMembers(); len(members) > 0 {
+		return members
+	}
+
+	if g.opts.singleFileCache {
+		var s snapshot
+		if err := g.getCached(g.cacheFile(directoryCacheFile), &s); err != nil {
+			return nil
+		}
+		return s.Members
+	}
+	var members []Member
+	if err := g.getCached(g.cacheFile(membersCacheFile), &members); err != nil {
+		return nil
+	}
+	return members
+}
+
+// trackRenames compares freshly fetched members with the previous ones by ID. Members whose login
+// changed get their old login added to PreviousLogins, and logins already recorded are carried over
+// since GitHub doesn't return them. Teams listing a renamed member's old login are returned with the
+// new one, copied so slices already handed out aren't changed. Members without an ID can't be tracked.
+func (g *GH) trackRenames(previous, members []Member, teams []Team) []Team {
+	byID := make(map[int64]Member, len(previous))
+	for _, m := range previous {
+		if m.ID != 0 {
+			byID[m.ID] = m
+		}
+	}
+
+	renames := make(map[string]string)
+	for i := range members {
+		old, ok := byID[members[i].ID]
+		if !ok || members[i].ID == 0 {
+			continue
+		}
+		logins := members[i].PreviousLogins
+		for _, login := range old.PreviousLogins {
+			logins = appendLogin(logins, login)
+		}
+		if !strings.EqualFold(old.Login, members[i].Login) {
+			g.logf("member %s was renamed to %s", old.Login, members[i].Login)
+			logins = appendLogin(logins, old.Login)
+			renames[strings.ToLower(old.Login)] = members[i].Login
+		}
+		// Someone who renamed back shouldn't list their current login as a previous one
+		current := []string{}
+		for _, login := range logins {
+			if !strings.EqualFold(login, members[i].Login) {
+				current = append(current, login)
+			}
+		}
+		if len(current) > 0 {
+			members[i].PreviousLogins = current
+		}
+	}
+	if len(renames) == 0 {
+		return teams
+	}
+
+	renamed := make([]Team, len(teams))
+	copy(renamed, teams)
+	for i := range renamed {
+		renamed[i].Members = renameLogins(renamed[i].Members, renames)
+		renamed[i].Maintainers = renameLogins(renamed[i].Maintainers, renames)
+	}
+	return renamed
+}
+
+// appendLogin adds login to logins unless it is already there ignoring case
+func appendLogin(logins []string, login string) []string {
+	for _, l := range logins {
+		if strings.EqualFold(l, login) {
+			return logins
+		}
+	}
+	return append(logins, login)
+}
+
+// renameLogins returns a copy of logins with old logins replaced by the new ones in renames, which is
+// keyed by lowercase login
+func renameLogins(logins []string, renames map[string]string) []string {
+	if logins == nil {
+		return nil
+	}
+	renamed := make([]string, len(logins))
+	for i, login := range logins {
+		renamed[i] = login
+		if to, ok := renames[strings.ToLower(login)]; ok {
+			renamed[i] = to
+		}
+	}
+	return renamed
+}
+
+// ResolveRenamed finds the member currently using login or, when nobody is, the member who used it
+// before renaming their account. Stored logins keep resolving after a rename as long as it was seen by
+// a refresh of this directory or one whose cache it loaded.
+func (g *GH) ResolveRenamed(login string) (Member, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if m, ok := g.findMember(login); ok {
+		return m, true
+	}
+	for _, m := range g.Members {
+		for _, previous := range m.PreviousLogins {
+			if strings.EqualFold(previous, login) {
+				return m, true
+			}
+		}
+	}
+	return Member{}, false
+}
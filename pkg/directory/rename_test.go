@@ -0,0 +1,118 @@
+package directory
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestTrackRenames(t *testing.T) {
+	previous := []Member{
+		Member{ID: 1, Login: "old1"},
+		Member{ID: 2, Login: "test2", PreviousLogins: []string{"older2"}},
+		Member{ID: 3, Login: "test3", PreviousLogins: []string{"Test3-new"}},
+		Member{Login: "noid"},
+	}
+	teams := []Team{Team{Name: "team1", Members: []string{"Old1", "test2"}, Maintainers: []string{"old1"}}}
+
+	cases := map[string]struct {
+		Member   Member
+		Expected []string
+	}{
+		"TestRenamed":        {Member: Member{ID: 1, Login: "new1"}, Expected: []string{"old1"}},
+		"TestCarriedOver":    {Member: Member{ID: 2, Login: "test2"}, Expected: []string{"older2"}},
+		"TestRenamedBack":    {Member: Member{ID: 3, Login: "test3-new"}, Expected: []string{"test3"}},
+		"TestWithoutID":      {Member: Member{Login: "renamed-noid"}, Expected: nil},
+		"TestUnknownID":      {Member: Member{ID: 9, Login: "test9"}, Expected: nil},
+		"TestCaseChangeOnly": {Member: Member{ID: 1, Login: "OLD1"}, Expected: nil},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			members := []Member{c.Member}
+			(&GH{}).trackRenames(previous, members, teams)
+			got := members[0].PreviousLogins
+			if len(got) != len(c.Expected) {
+				t.Fatalf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+			}
+			for i := range got {
+				if got[i] != c.Expected[i] {
+					t.Errorf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+				}
+			}
+		})
+	}
+
+	renamed := (&GH{}).trackRenames(previous, []Member{Member{ID: 1, Login: "new1"}}, teams)
+	if renamed[0].Members[0] != "new1" || renamed[0].Members[1] != "test2" || renamed[0].Maintainers[0] != "new1" {
+		t.Errorf("got: %+v, expected old1 to be replaced by new1", renamed[0])
+	}
+	if teams[0].Members[0] != "Old1" {
+		t.Errorf("got: %+v, expected the original teams to be left alone", teams[0])
+	}
+}
+
+func TestResolveRenamed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/user":
+			fmt.Fprint(w, `{"login":"new1"}`)
+		case "/orgs/test/members":
+			fmt.Fprint(w, `[{"login":"new1"},{"login":"test2"}]`)
+		case "/users/new1":
+			fmt.Fprint(w, `{"id":1,"login":"new1"}`)
+		case "/users/test2":
+			fmt.Fprint(w, `{"id":2,"login":"test2"}`)
+		case "/user/teams":
+			fmt.Fprint(w, `[]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+	g.Org = "test"
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+	g.UsersService = g.ghClient.Users
+	WithMaxMembers(10)(&g.opts)
+	g.setInfo(Info{
+		Members: []Member{Member{ID: 1, Login: "old1"}, Member{ID: 2, Login: "test2"}},
+		Teams:   []Team{Team{Name: "team1", Members: []string{"old1", "test2"}}},
+	})
+
+	if err := g.RefreshMembers(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := map[string]struct {
+		Login    string
+		Expected string
+		Found    bool
+	}{
+		"TestOldLogin":     {Login: "OLD1", Expected: "new1", Found: true},
+		"TestCurrentLogin": {Login: "new1", Expected: "new1", Found: true},
+		"TestUnchanged":    {Login: "test2", Expected: "test2", Found: true},
+		"TestUnknown":      {Login: "old2"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, ok := g.ResolveRenamed(c.Login)
+			if ok != c.Found || got.Login != c.Expected {
+				t.Errorf("Name: %s, got: %+v %v, expected: %s %v", name, got, ok, c.Expected, c.Found)
+			}
+		})
+	}
+
+	if _, ok := g.IsMember("old1"); ok {
+		t.Errorf("expected the old login not to be a member any more")
+	}
+	if teams := g.GetMemberTeams("new1"); len(teams) != 1 || teams[0] != "team1" {
+		t.Errorf("got: %v, expected the team to list the new login", teams)
+	}
+}
@@ -0,0 +1,405 @@
+package directory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RecipientKind says what a recipient string refers to
+type RecipientKind int
+
+const (
+	// RecipientAny is a plain name, which is looked up as a member login first and then as a team
+	RecipientAny RecipientKind = iota
+	// RecipientMember is a member login, written as @login
+	RecipientMember
+	// RecipientTeam is a team slug or name, written as team:name, org/team or @org/team
+	RecipientTeam
+	// RecipientAll is every member of the organization, written as "*" or GHAllTeam. A member or team
+	// named GHAllTeam is still found by that name, only "*" always means everyone.
+	RecipientAll
+)
+
+// teamRecipientPrefix marks a recipient as a team
+const teamRecipientPrefix = "team:"
+
+// ParseRecipient works out what kind of recipient s is and returns the value to look it up by, trimmed
+// of whitespace and the markers above. A team written as org/team keeps its org, which Resolve and
+// ResolveRecipients drop when it is the directory's own. An empty or bare marker gives an empty value.
+func ParseRecipient(s string) (kind RecipientKind, value string) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "*" || strings.ToLower(s) == GHAllTeam:
+		return RecipientAll, s
+	case len(s) >= len(teamRecipientPrefix) && strings.ToLower(s[:len(teamRecipientPrefix)]) == teamRecipientPrefix:
+		return RecipientTeam, strings.TrimSpace(s[len(teamRecipientPrefix):])
+	case strings.HasPrefix(s, "@"):
+		s = s[1:]
+		if strings.Contains(s, "/") {
+			return RecipientTeam, s
+		}
+		return RecipientMember, s
+	case strings.Contains(s, "/"):
+		return RecipientTeam, s
+	}
+	return RecipientAny, s
+}
+
+// ResolveRecipients expands a mix of member logins and team names into the members they refer to. The
+// result is sorted by login and contains each member once, no matter how many of the names include them.
+// "*" and GHAllTeam both stand for every member of the organization, like GetMatches("*"), unless a
+// member or team is named GHAllTeam. Names are read with ParseRecipient, so "@login" only matches a
+// member and "team:name" only a team.
+//
+// Names that aren't a member or a single team, or are a team whose members couldn't be fetched, are
+// returned as unresolved in the order given, so callers can warn about them instead of silently sharing
+// with fewer people than intended.
+func (g *GH) ResolveRecipients(names []string) ([]Member, []string) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.resolveRecipients(names)
+}
+
+// ResolveExcept resolves include and exclude like ResolveRecipients and returns the members of include
+// that aren't in exclude, such as everyone in "all" except the members of a contractors team. Names in
+// either list that don't resolve are ignored, except for an excluded team whose members couldn't be
+// fetched: nobody is returned then, as the members it should leave out aren't known.
+func (g *GH) ResolveExcept(include []string, exclude []string) []Member {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	for _, name := range exclude {
+		if _, isTeam, found := g.lookupRecipient(name); !found && isTeam {
+			g.logf("the members of excluded team %s couldn't be fetched, resolving to nobody", name)
+			return []Member{}
+		}
+	}
+	included, _ := g.resolveRecipients(include)
+	excluded, _ := g.resolveRecipients(exclude)
+	skip := make(map[string]struct{}, len(excluded))
+	for _, m := range excluded {
+		skip[strings.ToLower(m.Login)] = struct{}{}
+	}
+
+	members := []Member{}
+	for _, m := range included {
+		if _, ok := skip[strings.ToLower(m.Login)]; !ok {
+			members = append(members, m)
+		}
+	}
+	return members
+}
+
+// BlastRadius reports how many people a share with tokens would reach once teams are expanded and
+// members in several of them counted once, along with who they are, so callers can confirm before an
+// accidental org-wide send. Tokens are resolved like ResolveRecipients and those that don't resolve,
+// including teams whose members couldn't be fetched, reach nobody.
+func (g *GH) BlastRadius(tokens []string) (count int, members []Member) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	members, _ = g.resolveRecipients(tokens)
+	return len(members), members
+}
+
+// resolveRecipients is ResolveRecipients for callers that hold g.mu
+func (g *GH) resolveRecipients(names []string) ([]Member, []string) {
+	seen := make(map[string]struct{})
+	members := []Member{}
+	unresolved := []string{}
+	add := func(m Member) {
+		login := strings.ToLower(m.Login)
+		if _, ok := seen[login]; ok {
+			return
+		}
+		seen[login] = struct{}{}
+		members = append(members, m)
+	}
+
+	for _, name := range names {
+		found, isTeam, ok := g.lookupRecipient(name)
+		if !ok {
+			if isTeam {
+				g.logf("the members of team %s couldn't be fetched, leaving it unresolved", name)
+			}
+			unresolved = append(unresolved, name)
+			continue
+		}
+		for _, m := range found {
+			add(m)
+		}
+	}
+
+	ByMembers(sortMemberLogins).Sort(members)
+	return members, unresolved
+}
+
+// Resolve looks up a single recipient that may be a member login or a team, read with ParseRecipient and
+// checking members first. A team is expanded to its members sorted by login, and "*" or GHAllTeam count
+// as a team of everyone, though a member or team named GHAllTeam is found first. found is false when the
+// token is neither.
+func (g *GH) Resolve(token string) (members []Member, isTeam bool, found bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	members, isTeam, found = g.lookupRecipient(token)
+	if !found {
+		return []Member{}, false, false
+	}
+	ByMembers(sortMemberLogins).Sort(members)
+	return members, isTeam, true
+}
+
+// lookupRecipient finds the members a recipient string refers to, in no particular order. A team whose
+// members couldn't be fetched isn't found, with isTeam set so callers can tell. The caller must hold
+// g.mu.
+func (g *GH) lookupRecipient(token string) (members []Member, isTeam bool, found bool) {
+	kind, value := ParseRecipient(token)
+	if value == "" {
+		return nil, false, false
+	}
+	if kind == RecipientAll {
+		// A member or team really named GHAllTeam shouldn't become impossible to address
+		if value != "*" {
+			if members, isTeam, found := g.lookupNamed(RecipientAny, value); found || isTeam {
+				return members, isTeam, found
+			}
+		}
+		return g.everyone(), true, true
+	}
+	return g.lookupNamed(kind, value)
+}
+
+// lookupNamed finds the member or team named value, as lookupRecipient does for other kinds than
+// RecipientAll. The caller must hold g.mu.
+func (g *GH) lookupNamed(kind RecipientKind, value string) (members []Member, isTeam bool, found bool) {
+	if kind == RecipientMember || kind == RecipientAny {
+		if m, ok := g.findMember(value); ok && g.allowedRecipient(m.Login) {
+			return []Member{m}, false, true
+		}
+	}
+	if kind == RecipientTeam || kind == RecipientAny {
+		// The directory's own org is implied, other orgs only exist in merged directories
+		if i := strings.Index(value, "/"); i >= 0 && strings.EqualFold(value[:i], g.Org) {
+			value = value[i+1:]
+		}
+		if t, ok := g.findTeam(value); ok {
+			// Sharing with a team that seems to have no members would quietly reach nobody
+			if membersMissing(t) {
+				return nil, true, false
+			}
+			members = make([]Member, 0, len(t.Members))
+			for _, login := range t.Members {
+				if g.allowedRecipient(login) {
+					members = append(members, g.memberOrLogin(login))
+				}
+			}
+			return members, true, true
+		}
+	}
+	return nil, false, false
+}
+
+// AllTeam returns a team named GHAllTeam made up of every member, listed once each sorted by login, for
+// treating org-wide broadcasts like any other team. It isn't one of GetTeams.
+func (g *GH) AllTeam() Team {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	members := g.everyone()
+	logins := make([]string, 0, len(members))
+	for _, m := range members {
+		logins = append(logins, m.Login)
+	}
+	return Team{Name: GHAllTeam, Slug: GHAllTeam, Members: logins, Empty: len(logins) == 0}
+}
+
+// everyone returns every allowed member once, ignoring case in logins, sorted by login. The caller must
+// hold g.mu.
+func (g *GH) everyone() []Member {
+	seen := make(map[string]struct{}, len(g.Members))
+	members := make([]Member, 0, len(g.Members))
+	for _, m := range g.Members {
+		login := strings.ToLower(m.Login)
+		if _, ok := seen[login]; ok || !g.allowedRecipient(login) {
+			continue
+		}
+		seen[login] = struct{}{}
+		members = append(members, m)
+	}
+	ByMembers(sortMemberLogins).Sort(members)
+	return members
+}
+
+// allowedRecipient reports whether login passes WithRecipientBlocklist and WithRecipientAllowlist
+func (g *GH) allowedRecipient(login string) bool {
+	login = strings.ToLower(login)
+	if _, ok := g.opts.recipientBlocklist[login]; ok {
+		return false
+	}
+	if g.opts.recipientAllowlist == nil {
+		return true
+	}
+	_, ok := g.opts.recipientAllowlist[login]
+	return ok
+}
+
+// filterRecipients keeps the members allowedRecipient allows
+func (g *GH) filterRecipients(members []Member) []Member {
+	if g.opts.recipientBlocklist == nil && g.opts.recipientAllowlist == nil {
+		return members
+	}
+	allowed := []Member{}
+	for _, m := range members {
+		if g.allowedRecipient(m.Login) {
+			allowed = append(allowed, m)
+		}
+	}
+	return allowed
+}
+
+// filterTeamRecipients returns a copy of t without the logins allowedRecipient doesn't allow
+func (g *GH) filterTeamRecipients(t Team) Team {
+	if g.opts.recipientBlocklist == nil && g.opts.recipientAllowlist == nil {
+		return t
+	}
+	members := []string{}
+	for _, login := range t.Members {
+		if g.allowedRecipient(login) {
+			members = append(members, login)
+		}
+	}
+	t.Members = members
+	t.Empty = t.Empty || len(members) == 0
+	return t
+}
+
+// findMember looks up a member by login ignoring case. The caller must hold g.mu.
+func (g *GH) findMember(login string) (Member, bool) {
+	for _, m := range g.Members {
+		if strings.ToLower(login) == strings.ToLower(m.Login) {
+			return m, true
+		}
+	}
+	return Member{}, false
+}
+
+// findTeam looks up a single team by slug or name ignoring case. A name shared by several teams doesn't
+// match any of them, the slug has to be used instead. The caller must hold g.mu.
+func (g *GH) findTeam(name string) (Team, bool) {
+	teams := g.findTeams(name)
+	if len(teams) != 1 {
+		return Team{}, false
+	}
+	return teams[0], true
+}
+
+// findTeams returns the team with the given slug or, failing that, every team with the given name.
+// Either may be qualified as "org/team" to only look in that org, which tells apart teams from
+// MergeDirectories. A team name with a slash in it is still found unqualified. The caller must hold g.mu.
+func (g *GH) findTeams(name string) []Team {
+	lookup := strings.ToLower(name)
+	if i := strings.Index(lookup, "/"); i >= 0 {
+		if teams := g.findTeamsIn(lookup[:i], lookup[i+1:]); len(teams) > 0 {
+			return teams
+		}
+	}
+	return g.findTeamsIn("", lookup)
+}
+
+// findTeamsIn is findTeams for a lowercase slug or name in the lowercase org, or any org when it is
+// empty. The caller must hold g.mu.
+func (g *GH) findTeamsIn(org, lookup string) []Team {
+	inOrg := func(t Team) bool {
+		return org == "" || strings.ToLower(g.teamOrg(t)) == org
+	}
+
+	teams := []Team{}
+	for _, t := range g.Info.Teams {
+		if t.Slug != "" && lookup == strings.ToLower(t.Slug) && inOrg(t) {
+			teams = append(teams, t)
+		}
+	}
+	if len(teams) > 0 {
+		return teams
+	}
+
+	for _, t := range g.Info.Teams {
+		if lookup == strings.ToLower(t.Name) && inOrg(t) {
+			teams = append(teams, t)
+		}
+	}
+	return teams
+}
+
+// teamOrg is the org a team belongs to, which is only recorded on teams from MergeDirectories
+func (g *GH) teamOrg(t Team) string {
+	if t.Org != "" {
+		return t.Org
+	}
+	return g.Org
+}
+
+// qualifiedTeamName is the "org/slug" form that finds just this team
+func (g *GH) qualifiedTeamName(t Team) string {
+	name := t.Slug
+	if name == "" {
+		name = t.Name
+	}
+	if org := g.teamOrg(t); org != "" {
+		return org + "/" + name
+	}
+	return name
+}
+
+// ErrTeamNotFound is the cause of GetTeam's error for a team that isn't in the directory
+var ErrTeamNotFound = errors.New("team does not exist in directory")
+
+// AmbiguousTeamError is GetTeam's error for a name shared by several teams, such as teams with the same
+// name in merged orgs
+type AmbiguousTeamError struct {
+	Name string
+	// Candidates are the teams the name matched
+	Candidates []Team
+	// qualified are the names that find each candidate on its own
+	qualified []string
+}
+
+func (e *AmbiguousTeamError) Error() string {
+	return fmt.Sprintf("team name '%s' is used by %d teams, use one of %s", e.Name, len(e.Candidates), strings.Join(e.qualified, ", "))
+}
+
+// GetTeam looks up a team by slug or name, optionally qualified as "org/team". A name matching several
+// teams returns an *AmbiguousTeamError listing them rather than picking one.
+func (g *GH) GetTeam(name string) (Team, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	teams := g.findTeams(name)
+	switch len(teams) {
+	case 0:
+		return Team{}, errors.Wrap(ErrTeamNotFound, name)
+	case 1:
+		return teams[0], nil
+	}
+
+	e := &AmbiguousTeamError{Name: name, Candidates: teams}
+	for _, t := range teams {
+		e.qualified = append(e.qualified, g.qualifiedTeamName(t))
+	}
+	sort.Strings(e.qualified)
+	return Team{}, e
+}
+
+// memberOrLogin returns the known member for a login, or a member with only the login set if the
+// members and teams caches disagree. The caller must hold g.mu.
+func (g *GH) memberOrLogin(login string) Member {
+	if m, ok := g.findMember(login); ok {
+		return m
+	}
+	return Member{Login: login}
+}
@@ -0,0 +1,583 @@
+package directory
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func testResolveState() *GH {
+	g := &GH{}
+	g.Members = []Member{Member{Login: "test1", Name: "Test 1"}, Member{Login: "test2", Name: "Test 2"}, Member{Login: "test3", Name: "Test 3"}}
+	g.Info.Teams = []Team{
+		Team{Name: "team1", Members: []string{"test1", "test2"}},
+		Team{Name: "team2", Members: []string{"test2", "test3"}},
+		Team{Name: "team3", Members: []string{}},
+	}
+	g.indexTeams()
+	return g
+}
+
+func TestResolveRecipients(t *testing.T) {
+	cases := map[string]struct {
+		State      *GH
+		Names      []string
+		Expected   []string
+		Unresolved []string
+	}{
+		"TestMembers": {
+			State:    testResolveState(),
+			Names:    []string{"test2", "TEST1"},
+			Expected: []string{"test1", "test2"},
+		},
+		"TestOverlappingTeams": {
+			State:    testResolveState(),
+			Names:    []string{"team1", "team2"},
+			Expected: []string{"test1", "test2", "test3"},
+		},
+		"TestMemberAndTeam": {
+			State:    testResolveState(),
+			Names:    []string{"test1", "team1"},
+			Expected: []string{"test1", "test2"},
+		},
+		"TestEmptyTeam": {
+			State:    testResolveState(),
+			Names:    []string{"team3"},
+			Expected: []string{},
+		},
+		"TestStar": {
+			State:    testResolveState(),
+			Names:    []string{"*"},
+			Expected: []string{"test1", "test2", "test3"},
+		},
+		"TestAllWithTeam": {
+			State:    testResolveState(),
+			Names:    []string{"team1", "All"},
+			Expected: []string{"test1", "test2", "test3"},
+		},
+		"TestUnknown": {
+			State:      testResolveState(),
+			Names:      []string{"notthere", "test3", "tema1"},
+			Expected:   []string{"test3"},
+			Unresolved: []string{"notthere", "tema1"},
+		},
+		"TestAmbiguousTeam": {
+			State: func() *GH {
+				g := testResolveState()
+				g.Info.Teams = append(g.Info.Teams, Team{Name: "team1", Slug: "team1-again"})
+				return g
+			}(),
+			Names:      []string{"team1"},
+			Expected:   []string{},
+			Unresolved: []string{"team1"},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, unresolved := c.State.ResolveRecipients(c.Names)
+			checkLogins(t, name, got, c.Expected)
+			if len(unresolved) != len(c.Unresolved) {
+				t.Fatalf("Name: %s, got unresolved: %v, expected: %v", name, unresolved, c.Unresolved)
+			}
+			for i := range unresolved {
+				if unresolved[i] != c.Unresolved[i] {
+					t.Errorf("Name: %s, got unresolved: %v, expected: %v", name, unresolved, c.Unresolved)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRecipient(t *testing.T) {
+	cases := map[string]struct {
+		Input string
+		Kind  RecipientKind
+		Value string
+	}{
+		"TestPlain":        {Input: " test1 ", Kind: RecipientAny, Value: "test1"},
+		"TestMention":      {Input: "@test1", Kind: RecipientMember, Value: "test1"},
+		"TestTeamMention":  {Input: "@acme/team1", Kind: RecipientTeam, Value: "acme/team1"},
+		"TestOrgTeam":      {Input: "acme/team1", Kind: RecipientTeam, Value: "acme/team1"},
+		"TestTeamPrefix":   {Input: "Team: team1", Kind: RecipientTeam, Value: "team1"},
+		"TestStar":         {Input: "*", Kind: RecipientAll, Value: "*"},
+		"TestAll":          {Input: "ALL", Kind: RecipientAll, Value: "ALL"},
+		"TestBareMention":  {Input: "@", Kind: RecipientMember, Value: ""},
+		"TestEmpty":        {Input: "  ", Kind: RecipientAny, Value: ""},
+		"TestTeamNamedAll": {Input: "team:all", Kind: RecipientTeam, Value: "all"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			kind, value := ParseRecipient(c.Input)
+			if kind != c.Kind || value != c.Value {
+				t.Errorf("Name: %s, got: %v %q, expected: %v %q", name, kind, value, c.Kind, c.Value)
+			}
+		})
+	}
+}
+
+func TestResolveParsed(t *testing.T) {
+	cases := map[string]struct {
+		Token    string
+		Expected []string
+		Found    bool
+	}{
+		"TestMention":      {Token: "@test1", Expected: []string{"test1"}, Found: true},
+		"TestMentionTeam":  {Token: "@team1", Expected: []string{}},
+		"TestTeamPrefix":   {Token: "team:team2", Expected: []string{"test2", "test3"}, Found: true},
+		"TestTeamNotLogin": {Token: "team:test1", Expected: []string{}},
+		"TestOwnOrg":       {Token: "@Acme/team1", Expected: []string{"test1", "test2"}, Found: true},
+		"TestOtherOrg":     {Token: "other/team1", Expected: []string{}},
+		"TestEmpty":        {Token: "@", Expected: []string{}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			g := testResolveState()
+			g.Org = "acme"
+			got, _, found := g.Resolve(c.Token)
+			if found != c.Found {
+				t.Errorf("Name: %s, got found: %v, expected: %v", name, found, c.Found)
+			}
+			checkLogins(t, name, got, c.Expected)
+		})
+	}
+}
+
+func TestResolveExcept(t *testing.T) {
+	cases := map[string]struct {
+		Include  []string
+		Exclude  []string
+		Expected []string
+	}{
+		"TestEveryoneExceptTeam": {
+			Include:  []string{"all"},
+			Exclude:  []string{"team1"},
+			Expected: []string{"test3"},
+		},
+		"TestTeamExceptMember": {
+			Include:  []string{"team1", "team2"},
+			Exclude:  []string{"TEST2"},
+			Expected: []string{"test1", "test3"},
+		},
+		"TestNothingExcluded": {
+			Include:  []string{"team2"},
+			Exclude:  []string{"team3", "notthere"},
+			Expected: []string{"test2", "test3"},
+		},
+		"TestEverythingExcluded": {
+			Include:  []string{"test1"},
+			Exclude:  []string{"*"},
+			Expected: []string{},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			checkLogins(t, name, testResolveState().ResolveExcept(c.Include, c.Exclude), c.Expected)
+		})
+	}
+}
+
+func TestBlastRadius(t *testing.T) {
+	cases := map[string]struct {
+		Tokens   []string
+		Expected []string
+	}{
+		"TestOverlappingTeams": {
+			Tokens:   []string{"team1", "team2"},
+			Expected: []string{"test1", "test2", "test3"},
+		},
+		"TestMemberInTeam": {
+			Tokens:   []string{"team1", "@TEST1"},
+			Expected: []string{"test1", "test2"},
+		},
+		"TestEveryone": {
+			Tokens:   []string{"*", "team1"},
+			Expected: []string{"test1", "test2", "test3"},
+		},
+		"TestNobody": {
+			Tokens:   []string{"team3", "notthere"},
+			Expected: []string{},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			count, members := testResolveState().BlastRadius(c.Tokens)
+			if count != len(c.Expected) {
+				t.Errorf("Name: %s, got: %d, expected: %d", name, count, len(c.Expected))
+			}
+			checkLogins(t, name, members, c.Expected)
+		})
+	}
+}
+
+func TestRecipientLists(t *testing.T) {
+	cases := map[string]struct {
+		Opts       []Option
+		Tokens     []string
+		Expected   []string
+		Unresolved []string
+		Matches    []string
+		Team1      []string
+	}{
+		"TestNoLists": {
+			Tokens:     []string{"team1", "test3"},
+			Expected:   []string{"test1", "test2", "test3"},
+			Unresolved: []string{},
+			Matches:    []string{"test1", "test2", "test3"},
+			Team1:      []string{"test1", "test2"},
+		},
+		"TestBlocklist": {
+			Opts:       []Option{WithRecipientBlocklist([]string{"TEST2", "test3"})},
+			Tokens:     []string{"team1", "test3"},
+			Expected:   []string{"test1"},
+			Unresolved: []string{"test3"},
+			Matches:    []string{"test1"},
+			Team1:      []string{"test1"},
+		},
+		"TestAllowlist": {
+			Opts:       []Option{WithRecipientAllowlist([]string{"Test2", "test3"})},
+			Tokens:     []string{"*", "test1"},
+			Expected:   []string{"test2", "test3"},
+			Unresolved: []string{"test1"},
+			Matches:    []string{"test2", "test3"},
+			Team1:      []string{"test2"},
+		},
+		"TestBothLists": {
+			Opts:       []Option{WithRecipientAllowlist([]string{"test1", "test2"}), WithRecipientBlocklist([]string{"test2"})},
+			Tokens:     []string{"team2"},
+			Expected:   []string{},
+			Unresolved: []string{},
+			Matches:    []string{"test1"},
+			Team1:      []string{"test1"},
+		},
+		"TestEmptyAllowlist": {
+			Opts:       []Option{WithRecipientAllowlist([]string{})},
+			Tokens:     []string{"team1", "*"},
+			Expected:   []string{},
+			Unresolved: []string{},
+			Matches:    []string{},
+			Team1:      []string{},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			g := testResolveState()
+			for _, o := range c.Opts {
+				o(&g.opts)
+			}
+
+			members, unresolved := g.ResolveRecipients(c.Tokens)
+			checkLogins(t, name, members, c.Expected)
+			if strings.Join(unresolved, ",") != strings.Join(c.Unresolved, ",") {
+				t.Errorf("Name: %s, got: %v, expected unresolved: %v", name, unresolved, c.Unresolved)
+			}
+
+			team1, _, found := g.Resolve("team1")
+			if !found {
+				t.Fatalf("Name: %s, expected team1 to resolve", name)
+			}
+			checkLogins(t, name, team1, c.Team1)
+
+			matches := g.GetMatches("test")
+			checkLogins(t, name, matches.Members, c.Matches)
+			for _, team := range g.GetMatches("*").Teams {
+				if team.Name == "team1" && strings.Join(team.Members, ",") != strings.Join(c.Team1, ",") {
+					t.Errorf("Name: %s, got: %v, expected the members of team1 in matches: %v", name, team.Members, c.Team1)
+				}
+			}
+		})
+	}
+
+	// Filtering the matches doesn't change the loaded teams
+	g := testResolveState()
+	WithRecipientBlocklist([]string{"test1"})(&g.opts)
+	g.GetMatches("team")
+	if len(g.Info.Teams[0].Members) != 2 {
+		t.Errorf("got: %v, expected the loaded team to keep its members", g.Info.Teams[0].Members)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	cases := map[string]struct {
+		Token    string
+		Expected []string
+		IsTeam   bool
+		Found    bool
+	}{
+		"TestMember":    {Token: "TEST2", Expected: []string{"test2"}, Found: true},
+		"TestTeam":      {Token: "team2", Expected: []string{"test2", "test3"}, IsTeam: true, Found: true},
+		"TestEmptyTeam": {Token: "team3", Expected: []string{}, IsTeam: true, Found: true},
+		"TestEveryone":  {Token: "all", Expected: []string{"test1", "test2", "test3"}, IsTeam: true, Found: true},
+		"TestUnknown":   {Token: "notthere", Expected: []string{}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, isTeam, found := testResolveState().Resolve(c.Token)
+			if isTeam != c.IsTeam || found != c.Found {
+				t.Errorf("Name: %s, got isTeam: %v found: %v, expected: %v %v", name, isTeam, found, c.IsTeam, c.Found)
+			}
+			checkLogins(t, name, got, c.Expected)
+		})
+	}
+}
+
+func TestTeamNameCollisions(t *testing.T) {
+	g := &GH{}
+	g.Info.Teams = []Team{
+		Team{Name: "ops", Slug: "ops", Members: []string{"test1"}},
+		Team{Name: "Platform", Slug: "eng-platform", Members: []string{"test2"}},
+		Team{Name: "Platform", Slug: "data-platform", Members: []string{"test3"}},
+	}
+
+	cases := map[string]struct {
+		Lookup   string
+		Teams    int
+		Expected []string
+	}{
+		"TestUniqueName": {
+			Lookup:   "ops",
+			Teams:    1,
+			Expected: []string{"test1"},
+		},
+		"TestAmbiguousName": {
+			Lookup:   "platform",
+			Teams:    2,
+			Expected: []string{},
+		},
+		"TestSlug": {
+			Lookup:   "data-platform",
+			Teams:    1,
+			Expected: []string{"test3"},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := g.GetTeamsByName(c.Lookup); len(got) != c.Teams {
+				t.Errorf("Name: %s, got: %+v, expected %d teams", name, got, c.Teams)
+			}
+			_, ok := g.IsTeam(c.Lookup)
+			if ok != (c.Teams == 1) {
+				t.Errorf("Name: %s, got IsTeam: %v, expected: %v", name, ok, c.Teams == 1)
+			}
+			got := g.GetTeamMembers(c.Lookup)
+			if len(got) != len(c.Expected) {
+				t.Fatalf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+			}
+			for i := range got {
+				if got[i] != c.Expected[i] {
+					t.Errorf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+				}
+			}
+		})
+	}
+}
+
+func TestAllTeam(t *testing.T) {
+	g := testResolveState()
+	// The same member seen through several teams, and once more with different case, as after merging
+	// directories that disagree
+	g.Members = append(g.Members, Member{Login: "TEST2"}, Member{Login: "test1"})
+	g.Info.Teams = append(g.Info.Teams, Team{Name: "team4", Members: []string{"test1", "test2", "test3"}})
+	g.indexTeams()
+
+	all := g.AllTeam()
+	if all.Name != GHAllTeam || all.Empty {
+		t.Errorf("got: %+v, expected a non-empty %s team", all, GHAllTeam)
+	}
+	expected := []string{"test1", "test2", "test3"}
+	if len(all.Members) != len(expected) {
+		t.Fatalf("got: %v, expected: %v", all.Members, expected)
+	}
+	for i := range all.Members {
+		if all.Members[i] != expected[i] {
+			t.Errorf("got: %v, expected: %v", all.Members, expected)
+		}
+	}
+
+	got, _, _ := g.Resolve(GHAllTeam)
+	checkLogins(t, "TestResolveAll", got, expected)
+	got, _ = g.ResolveRecipients([]string{"team1", "team4", "*"})
+	checkLogins(t, "TestResolveRecipientsAll", got, expected)
+}
+
+func TestNamedAll(t *testing.T) {
+	withMember := func() *GH {
+		g := testResolveState()
+		g.Members = append(g.Members, Member{Login: "all"})
+		return g
+	}
+	withTeam := func() *GH {
+		g := testResolveState()
+		g.Info.Teams = append(g.Info.Teams, Team{Name: "all", Members: []string{"test3"}})
+		g.indexTeams()
+		return g
+	}
+
+	cases := map[string]struct {
+		State    *GH
+		Token    string
+		Expected []string
+		IsTeam   bool
+	}{
+		"TestMember":         {State: withMember(), Token: "all", Expected: []string{"all"}},
+		"TestMemberUpper":    {State: withMember(), Token: "ALL", Expected: []string{"all"}},
+		"TestMemberPrefixed": {State: withMember(), Token: "@all", Expected: []string{"all"}},
+		"TestMemberStar":     {State: withMember(), Token: "*", Expected: []string{"all", "test1", "test2", "test3"}, IsTeam: true},
+		"TestTeam":           {State: withTeam(), Token: "all", Expected: []string{"test3"}, IsTeam: true},
+		"TestTeamPrefixed":   {State: withTeam(), Token: "team:all", Expected: []string{"test3"}, IsTeam: true},
+		"TestTeamStar":       {State: withTeam(), Token: "*", Expected: []string{"test1", "test2", "test3"}, IsTeam: true},
+		"TestNoneNamed":      {State: testResolveState(), Token: "all", Expected: []string{"test1", "test2", "test3"}, IsTeam: true},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, isTeam, found := c.State.Resolve(c.Token)
+			if !found || isTeam != c.IsTeam {
+				t.Errorf("Name: %s, got: %v %v, expected: true %v", name, found, isTeam, c.IsTeam)
+			}
+			checkLogins(t, name, got, c.Expected)
+
+			got, unresolved := c.State.ResolveRecipients([]string{c.Token})
+			if len(unresolved) != 0 {
+				t.Errorf("Name: %s, got: %v, expected nothing unresolved", name, unresolved)
+			}
+			checkLogins(t, name, got, c.Expected)
+		})
+	}
+}
+
+func TestIncompleteTeamRecipients(t *testing.T) {
+	g := testResolveState()
+	g.Info.Teams = append(g.Info.Teams,
+		// The members couldn't be fetched
+		Team{Name: "team4", Incomplete: true},
+		// Only the repositories couldn't be fetched
+		Team{Name: "team5", Members: []string{"test1"}, Incomplete: true},
+		Team{Name: "team6", Members: []string{}, Empty: true, Incomplete: true},
+		Team{Name: "all", Incomplete: true},
+	)
+	g.indexTeams()
+
+	cases := map[string]struct {
+		Token    string
+		Found    bool
+		Expected []string
+	}{
+		"TestMembersMissing": {Token: "team4", Expected: []string{}},
+		"TestPrefixed":       {Token: "team:team4", Expected: []string{}},
+		"TestReposMissing":   {Token: "team5", Found: true, Expected: []string{"test1"}},
+		"TestEmpty":          {Token: "team6", Found: true, Expected: []string{}},
+		// Not everyone, the team named all is meant
+		"TestNamedAll": {Token: "all", Expected: []string{}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, _, found := g.Resolve(c.Token)
+			if found != c.Found {
+				t.Errorf("Name: %s, got found: %v, expected: %v", name, found, c.Found)
+			}
+			checkLogins(t, name, got, c.Expected)
+
+			got, unresolved := g.ResolveRecipients([]string{c.Token})
+			if c.Found == (len(unresolved) != 0) {
+				t.Errorf("Name: %s, got unresolved: %v, expected found: %v", name, unresolved, c.Found)
+			}
+			checkLogins(t, name, got, c.Expected)
+
+			if count, _ := g.BlastRadius([]string{c.Token}); count != len(c.Expected) {
+				t.Errorf("Name: %s, got: %d, expected: %d", name, count, len(c.Expected))
+			}
+		})
+	}
+
+	// The members the team should leave out aren't known, so nobody is safe to include
+	checkLogins(t, "TestExceptIncomplete", g.ResolveExcept([]string{"*"}, []string{"team4"}), []string{})
+	checkLogins(t, "TestExceptComplete", g.ResolveExcept([]string{"*"}, []string{"team5"}), []string{"test2", "test3"})
+}
+
+func TestQualifiedTeams(t *testing.T) {
+	merged := &GH{}
+	merged.Info.Teams = []Team{
+		Team{Name: "Platform", Slug: "platform", Org: "acme", Members: []string{"test1"}},
+		Team{Name: "Platform", Slug: "platform", Org: "other", Members: []string{"test2"}},
+		Team{Name: "Ops", Slug: "ops", Org: "acme", Members: []string{"test3"}},
+		Team{Name: "a/b", Slug: "a-b", Org: "acme", Members: []string{"test1"}},
+	}
+	single := &GH{}
+	single.Org = "acme"
+	single.Info.Teams = []Team{Team{Name: "Site Reliability", Slug: "sre", Members: []string{"test1"}}}
+
+	cases := map[string]struct {
+		State      *GH
+		Lookup     string
+		Expected   []string
+		Candidates []string
+	}{
+		"TestQualifiedSlug":   {State: merged, Lookup: "Other/platform", Expected: []string{"test2"}},
+		"TestQualifiedName":   {State: merged, Lookup: "acme/Platform", Expected: []string{"test1"}},
+		"TestUnambiguousBare": {State: merged, Lookup: "ops", Expected: []string{"test3"}},
+		"TestAmbiguousBare":   {State: merged, Lookup: "platform", Candidates: []string{"acme/platform", "other/platform"}},
+		"TestWrongOrg":        {State: merged, Lookup: "other/ops"},
+		"TestSlashInName":     {State: merged, Lookup: "a/b", Expected: []string{"test1"}},
+		"TestOwnOrg":          {State: single, Lookup: "ACME/sre", Expected: []string{"test1"}},
+		"TestOwnOrgName":      {State: single, Lookup: "acme/site reliability", Expected: []string{"test1"}},
+		"TestOtherOrg":        {State: single, Lookup: "other/sre"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			team, err := c.State.GetTeam(c.Lookup)
+			_, isTeam := c.State.IsTeam(c.Lookup)
+			members := c.State.GetTeamMembers(c.Lookup)
+
+			switch {
+			case c.Expected != nil:
+				if err != nil || !isTeam {
+					t.Fatalf("Name: %s, got: %v %v, expected a single team", name, err, isTeam)
+				}
+				for _, got := range [][]string{team.Members, members} {
+					if len(got) != len(c.Expected) || got[0] != c.Expected[0] {
+						t.Errorf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+					}
+				}
+			case c.Candidates != nil:
+				ambiguous, ok := err.(*AmbiguousTeamError)
+				if !ok || len(ambiguous.Candidates) != len(c.Candidates) {
+					t.Fatalf("Name: %s, got: %v, expected an ambiguous team error", name, err)
+				}
+				for i := range c.Candidates {
+					if ambiguous.qualified[i] != c.Candidates[i] {
+						t.Errorf("Name: %s, got: %v, expected: %v", name, ambiguous.qualified, c.Candidates)
+					}
+				}
+				if isTeam || len(members) != 0 {
+					t.Errorf("Name: %s, got: %v %v, expected no single team", name, isTeam, members)
+				}
+			default:
+				if errors.Cause(err) != ErrTeamNotFound || isTeam || len(members) != 0 {
+					t.Errorf("Name: %s, got: %v %v %v, expected the team not to be found", name, err, isTeam, members)
+				}
+			}
+		})
+	}
+}
+
+func checkLogins(t *testing.T, name string, got []Member, expected []string) {
+	if len(got) != len(expected) {
+		t.Fatalf("Name: %s, got: %+v, expected: %v", name, got, expected)
+	}
+	for i := range got {
+		if got[i].Login != expected[i] {
+			t.Errorf("Name: %s, got: %+v, expected: %v", name, got, expected)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package directory
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy controls how API calls that failed for a transient reason are retried: a request or page
+// that timed out on its own, a 5xx from GitHub, or hitting the rate limit. Other errors are returned
+// right away.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of tries including the first. One disables retries.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry, doubled for every retry after it
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between tries. A rate limit that resets later than this isn't waited for.
+	MaxDelay time.Duration
+	// Jitter randomly shortens or lengthens each wait by up to this fraction of it, such as 0.2
+	Jitter float64
+}
+
+// DefaultRetryPolicy is used unless WithRetryPolicy is given
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   250 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+	Jitter:      0.2,
+}
+
+func (g *GH) retryPolicy() RetryPolicy {
+	if g.opts.retryPolicy.MaxAttempts > 0 {
+		return g.opts.retryPolicy
+	}
+	return DefaultRetryPolicy
+}
+
+// callAPI makes a GitHub API call, retrying it as the retry policy allows. Every try is counted as an
+// API call and waits its turn with the adaptive throttle.
+func (g *GH) callAPI(ctx context.Context, scope string, call func(context.Context) (*github.Response, error)) (*github.Response, error) {
+	policy := g.retryPolicy()
+
+	var resp *github.Response
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err := g.throttle.acquire(ctx); err != nil {
+			return nil, err
+		}
+		g.count(MetricAPICall, scope)
+		resp, err = call(ctx)
+		g.throttle.release(resp)
+
+		// Nothing to gain from retrying once the caller's context is done
+		if err == nil || attempt >= policy.MaxAttempts || ctx.Err() != nil {
+			return resp, err
+		}
+		delay, ok := policy.retryDelay(attempt, err)
+		if !ok {
+			return resp, err
+		}
+
+		g.logf("retrying %s after %v: %v", scope, delay, err)
+		select {
+		case <-ctx.Done():
+			return resp, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// listPage requests a single page, giving each try its own page context so one slow page doesn't use
+// up the time of the tries after it
+func (g *GH) listPage(ctx context.Context, scope string, list func(context.Context) (*github.Response, error)) (*github.Response, error) {
+	return g.callAPI(ctx, scope, func(ctx context.Context) (*github.Response, error) {
+		pageCtx, cancel := g.pageContext(ctx)
+		defer cancel()
+		return list(pageCtx)
+	})
+}
+
+// retryDelay returns how long to wait before retrying after the given attempt failed with err, or false
+// when err isn't worth retrying
+func (p RetryPolicy) retryDelay(attempt int, err error) (time.Duration, bool) {
+	switch e := errors.Cause(err).(type) {
+	case *github.RateLimitError:
+		wait := time.Until(e.Rate.Reset.Time)
+		if wait > p.MaxDelay {
+			return 0, false
+		}
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	case *github.AbuseRateLimitError:
+		if e.RetryAfter != nil {
+			if *e.RetryAfter > p.MaxDelay {
+				return 0, false
+			}
+			return *e.RetryAfter, true
+		}
+		return p.backoff(attempt), true
+	case *github.ErrorResponse:
+		if e.Response != nil && e.Response.StatusCode >= http.StatusInternalServerError {
+			return p.backoff(attempt), true
+		}
+		return 0, false
+	}
+
+	if isDeadlineExceeded(err) {
+		return p.backoff(attempt), true
+	}
+	return 0, false
+}
+
+// backoff is the exponential delay after the given attempt, capped and with jitter applied
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 && delay > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(delay))
+	}
+	return delay
+}
+
+func isDeadlineExceeded(err error) bool {
+	err = errors.Cause(err)
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	return err == context.DeadlineExceeded
+}
@@ -0,0 +1,144 @@
+package directory
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+func TestListPageRetries(t *testing.T) {
+	g := &GH{}
+	WithRetryPolicy(RetryPolicy{MaxAttempts: 3})(&g.opts)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := map[string]struct {
+		Ctx      context.Context
+		Errs     []error
+		Calls    int
+		Expected error
+	}{
+		"TestSuccess": {
+			Ctx:   context.Background(),
+			Errs:  []error{nil},
+			Calls: 1,
+		},
+		"TestRetryDeadline": {
+			Ctx:   context.Background(),
+			Errs:  []error{context.DeadlineExceeded, nil},
+			Calls: 2,
+		},
+		"TestGiveUp": {
+			Ctx:      context.Background(),
+			Errs:     []error{context.DeadlineExceeded, context.DeadlineExceeded, context.DeadlineExceeded},
+			Calls:    3,
+			Expected: context.DeadlineExceeded,
+		},
+		"TestNoRetryOtherError": {
+			Ctx:      context.Background(),
+			Errs:     []error{errors.New("bad credentials")},
+			Calls:    1,
+			Expected: errors.New("bad credentials"),
+		},
+		"TestNoRetryParentDone": {
+			Ctx:      cancelled,
+			Errs:     []error{context.DeadlineExceeded},
+			Calls:    1,
+			Expected: context.DeadlineExceeded,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+			_, err := g.listPage(c.Ctx, "test", func(context.Context) (*github.Response, error) {
+				err := c.Errs[calls]
+				calls++
+				return &github.Response{}, err
+			})
+			if calls != c.Calls {
+				t.Errorf("Name: %s, got %d calls, expected %d", name, calls, c.Calls)
+			}
+			if (err == nil) != (c.Expected == nil) || (err != nil && err.Error() != c.Expected.Error()) {
+				t.Errorf("Name: %s, got: %v, expected: %v", name, err, c.Expected)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	soon := github.Timestamp{Time: time.Now().Add(3 * time.Second)}
+	later := github.Timestamp{Time: time.Now().Add(time.Hour)}
+	retryAfter := 2 * time.Second
+
+	cases := map[string]struct {
+		Attempt  int
+		Err      error
+		Retry    bool
+		MinDelay time.Duration
+		MaxDelay time.Duration
+	}{
+		"TestDeadline":        {Attempt: 1, Err: context.DeadlineExceeded, Retry: true, MinDelay: time.Second, MaxDelay: time.Second},
+		"TestBackoff":         {Attempt: 3, Err: context.DeadlineExceeded, Retry: true, MinDelay: 4 * time.Second, MaxDelay: 4 * time.Second},
+		"TestBackoffCapped":   {Attempt: 9, Err: context.DeadlineExceeded, Retry: true, MinDelay: 10 * time.Second, MaxDelay: 10 * time.Second},
+		"TestServerError":     {Attempt: 1, Err: errors.Wrap(testErrorResponse(http.StatusBadGateway, ""), "unable to get teams"), Retry: true, MinDelay: time.Second, MaxDelay: time.Second},
+		"TestNotFound":        {Attempt: 1, Err: testErrorResponse(http.StatusNotFound, "Not Found"), Retry: false},
+		"TestRateLimitSoon":   {Attempt: 1, Err: &github.RateLimitError{Rate: github.Rate{Reset: soon}}, Retry: true, MinDelay: 2 * time.Second, MaxDelay: 3 * time.Second},
+		"TestRateLimitLater":  {Attempt: 1, Err: &github.RateLimitError{Rate: github.Rate{Reset: later}}, Retry: false},
+		"TestAbuseRetryAfter": {Attempt: 1, Err: &github.AbuseRateLimitError{RetryAfter: &retryAfter}, Retry: true, MinDelay: retryAfter, MaxDelay: retryAfter},
+		"TestOtherError":      {Attempt: 1, Err: errors.New("bad credentials"), Retry: false},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			delay, ok := policy.retryDelay(c.Attempt, c.Err)
+			if ok != c.Retry {
+				t.Fatalf("Name: %s, got retry: %v, expected: %v", name, ok, c.Retry)
+			}
+			if ok && (delay < c.MinDelay || delay > c.MaxDelay) {
+				t.Errorf("Name: %s, got delay: %v, expected between %v and %v", name, delay, c.MinDelay, c.MaxDelay)
+			}
+		})
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if d := policy.backoff(1); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("got: %v, expected the jitter to stay within half the delay", d)
+		}
+	}
+}
+
+func TestCallAPIRetriesServerErrors(t *testing.T) {
+	g := &GH{}
+	WithRetryPolicy(RetryPolicy{MaxAttempts: 4})(&g.opts)
+
+	calls := 0
+	_, err := g.callAPI(context.Background(), "test", func(context.Context) (*github.Response, error) {
+		calls++
+		if calls < 3 {
+			return nil, testErrorResponse(http.StatusServiceUnavailable, "")
+		}
+		return &github.Response{}, nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("got: %v after %d calls, expected success on the third", err, calls)
+	}
+
+	calls = 0
+	WithRetryPolicy(RetryPolicy{MaxAttempts: 1})(&g.opts)
+	g.callAPI(context.Background(), "test", func(context.Context) (*github.Response, error) {
+		calls++
+		return nil, testErrorResponse(http.StatusServiceUnavailable, "")
+	})
+	if calls != 1 {
+		t.Errorf("got %d calls, expected a single attempt to disable retries", calls)
+	}
+}
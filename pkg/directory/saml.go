@@ -0,0 +1,108 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+// samlIdentitiesQuery pages through the external identities of an org's SAML identity provider
+const samlIdentitiesQuery = `query($org: String!, $first: Int!, $cursor: String) {
+  organization(login: $org) {
+    samlIdentityProvider {
+      externalIdentities(first: $first, after: $cursor) {
+        pageInfo { hasNextPage endCursor }
+        nodes { samlIdentity { nameId } user { login } }
+      }
+    }
+  }
+}`
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphQLError struct {
+	Message string `json:"message"`
+}
+
+type samlIdentitiesResponse struct {
+	Data struct {
+		Organization struct {
+			SAMLIdentityProvider *struct {
+				ExternalIdentities struct {
+					PageInfo struct {
+						HasNextPage bool   `json:"hasNextPage"`
+						EndCursor   string `json:"endCursor"`
+					} `json:"pageInfo"`
+					Nodes []struct {
+						SAMLIdentity struct {
+							NameID string `json:"nameId"`
+						} `json:"samlIdentity"`
+						User *struct {
+							Login string `json:"login"`
+						} `json:"user"`
+					} `json:"nodes"`
+				} `json:"externalIdentities"`
+			} `json:"samlIdentityProvider"`
+		} `json:"organization"`
+	} `json:"data"`
+	Errors []graphQLError `json:"errors"`
+}
+
+// graphQLPath returns the GraphQL endpoint relative to the client's REST base URL. Enterprise servers
+// serve REST under /api/v3/ and GraphQL at /api/graphql.
+func (g *GH) graphQLPath() string {
+	if strings.HasSuffix(g.ghClient.BaseURL.Path, "/v3/") {
+		return "../graphql"
+	}
+	return "graphql"
+}
+
+// getSAMLIdentities returns the SAML NameID of each linked member keyed by lowercase login. Orgs without
+// SAML single sign-on have no identities. It needs a token with admin:org.
+func (g *GH) getSAMLIdentities(ctx context.Context) (map[string]string, error) {
+	nameIDs := make(map[string]string)
+	var cursor *string
+
+	for {
+		req, err := g.ghClient.NewRequest("POST", g.graphQLPath(), graphQLRequest{
+			Query:     samlIdentitiesQuery,
+			Variables: map[string]interface{}{"org": g.Org, "first": g.opts.perPage, "cursor": cursor},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to create request")
+		}
+
+		var page samlIdentitiesResponse
+		if _, err := g.listPage(ctx, "list_saml_identities", func(pageCtx context.Context) (*github.Response, error) {
+			page = samlIdentitiesResponse{}
+			return g.ghClient.Do(pageCtx, req, &page)
+		}); err != nil {
+			return nil, g.wrapError(err, "unable to get SAML identities from GitHub")
+		}
+		if len(page.Errors) > 0 {
+			return nil, fmt.Errorf("unable to get SAML identities from GitHub: %s", page.Errors[0].Message)
+		}
+
+		provider := page.Data.Organization.SAMLIdentityProvider
+		if provider == nil {
+			return nameIDs, nil
+		}
+		for _, node := range provider.ExternalIdentities.Nodes {
+			if node.User != nil && node.SAMLIdentity.NameID != "" {
+				nameIDs[strings.ToLower(node.User.Login)] = node.SAMLIdentity.NameID
+			}
+		}
+
+		if !provider.ExternalIdentities.PageInfo.HasNextPage {
+			return nameIDs, nil
+		}
+		endCursor := provider.ExternalIdentities.PageInfo.EndCursor
+		cursor = &endCursor
+	}
+}
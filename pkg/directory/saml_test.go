@@ -0,0 +1,103 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestGetSAMLIdentities(t *testing.T) {
+	cases := map[string]struct {
+		Pages    []string
+		Expected map[string]string
+		Error    bool
+	}{
+		"TestPaged": {
+			Pages: []string{
+				`{"data":{"organization":{"samlIdentityProvider":{"externalIdentities":{"pageInfo":{"hasNextPage":true,"endCursor":"c1"},"nodes":[{"samlIdentity":{"nameId":"dtaylor@example.com"},"user":{"login":"DavidT"}}]}}}}}`,
+				`{"data":{"organization":{"samlIdentityProvider":{"externalIdentities":{"pageInfo":{"hasNextPage":false},"nodes":[{"samlIdentity":{"nameId":"unlinked"},"user":null},{"samlIdentity":{"nameId":"selse"},"user":{"login":"test2"}}]}}}}}`,
+			},
+			Expected: map[string]string{"davidt": "dtaylor@example.com", "test2": "selse"},
+		},
+		"TestNoSAML": {
+			Pages:    []string{`{"data":{"organization":{"samlIdentityProvider":null}}}`},
+			Expected: map[string]string{},
+		},
+		"TestGraphQLError": {
+			Pages: []string{`{"data":null,"errors":[{"message":"must be an organization owner"}]}`},
+			Error: true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			page := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body graphQLRequest
+				if r.URL.Path != "/graphql" || json.NewDecoder(r.Body).Decode(&body) != nil {
+					http.NotFound(w, r)
+					return
+				}
+				if page > 0 && body.Variables["cursor"] != "c1" {
+					t.Errorf("Name: %s, got cursor: %v, expected: c1", name, body.Variables["cursor"])
+				}
+				fmt.Fprint(w, c.Pages[page])
+				page++
+			}))
+			defer server.Close()
+
+			g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+			g.Org = "test"
+			g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+
+			got, err := g.getSAMLIdentities(context.Background())
+			if c.Error {
+				if err == nil {
+					t.Errorf("Name: %s, expected an error", name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Name: %s, unexpected error: %v", name, err)
+			}
+			if len(got) != len(c.Expected) {
+				t.Fatalf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+			}
+			for login, nameID := range c.Expected {
+				if got[login] != nameID {
+					t.Errorf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+				}
+			}
+		})
+	}
+}
+
+func TestGraphQLPath(t *testing.T) {
+	cases := map[string]struct {
+		BaseURL  string
+		Expected string
+	}{
+		"TestGitHub":     {BaseURL: "https://api.github.com/", Expected: "https://api.github.com/graphql"},
+		"TestEnterprise": {BaseURL: "https://github.example.com/api/v3/", Expected: "https://github.example.com/api/graphql"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			g := &GH{ghClient: github.NewClient(nil)}
+			g.ghClient.BaseURL, _ = url.Parse(c.BaseURL)
+			got, err := g.ghClient.BaseURL.Parse(g.graphQLPath())
+			if err != nil {
+				t.Fatalf("Name: %s, unexpected error: %v", name, err)
+			}
+			if got.String() != c.Expected {
+				t.Errorf("Name: %s, got: %s, expected: %s", name, got, c.Expected)
+			}
+		})
+	}
+}
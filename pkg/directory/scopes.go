@@ -0,0 +1,180 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ScopeReadOrg lets the token list members and teams, which psst always needs
+	ScopeReadOrg = "read:org"
+	// ScopeUserEmail lets the token see members' email addresses
+	ScopeUserEmail = "user:email"
+	// ScopeAdminOrg lets the token list pending invitations, as with WithPendingMembers
+	ScopeAdminOrg = "admin:org"
+
+	oauthScopesHeader = "X-OAuth-Scopes"
+	// fineGrainedPrefix starts fine-grained personal access tokens, which have permissions rather than scopes
+	fineGrainedPrefix = "github_pat_"
+)
+
+var (
+	// ErrOrgNotFound is the cause of OrgAccessible's error when the org doesn't exist or the token can't
+	// see it, which GitHub doesn't tell apart
+	ErrOrgNotFound = errors.New("organization not found")
+	// ErrOrgForbidden is the cause of OrgAccessible's error when the token is refused access to the org,
+	// such as when the org requires SAML single sign-on the token isn't authorized for
+	ErrOrgForbidden = errors.New("access to organization is forbidden")
+)
+
+// impliedScopes lists the scopes granted along with a broader one
+var impliedScopes = map[string][]string{
+	"admin:org": {"write:org", "read:org"},
+	"write:org": {"read:org"},
+	"user":      {"read:user", "user:email", "user:follow"},
+	"repo":      {"repo:status", "repo_deployment", "public_repo", "repo:invite"},
+}
+
+// Validate checks that the token has read:org and any additional scopes given, so a missing scope is
+// reported up front instead of as a confusing failure partway through a fetch. Tokens that don't report
+// their scopes, such as GitHub App tokens, can't be checked and are assumed to be fine. Fine-grained
+// tokens are checked by reading the org's members instead, and any additional scopes are not checked.
+func (g *GH) Validate(scopes ...string) error {
+	return g.ValidateContext(context.Background(), scopes...)
+}
+
+// ValidateContext is like Validate but uses the provided context for the API call
+func (g *GH) ValidateContext(ctx context.Context, scopes ...string) error {
+	if token, err := g.token(); err == nil && strings.HasPrefix(token, fineGrainedPrefix) {
+		return g.validateOrgAccess(ctx)
+	}
+
+	req, err := g.ghClient.NewRequest("GET", "user", nil)
+	if err != nil {
+		return errors.Wrap(err, "unable to create request")
+	}
+	resp, err := g.callAPI(ctx, "get_user", func(ctx context.Context) (*github.Response, error) {
+		return g.ghClient.Do(ctx, req, nil)
+	})
+	if err != nil {
+		return g.wrapError(err, "unable to check token scopes")
+	}
+
+	header, ok := resp.Header[http.CanonicalHeaderKey(oauthScopesHeader)]
+	if !ok {
+		return nil
+	}
+	missing := missingScopes(strings.Join(header, ","), append([]string{ScopeReadOrg}, scopes...))
+	if len(missing) > 0 {
+		return fmt.Errorf("GitHub token is missing the %s scope(s)", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// validateOrgAccess checks that the token can list the org's members, for tokens whose scopes header
+// doesn't say so
+func (g *GH) validateOrgAccess(ctx context.Context) error {
+	opts := &github.ListMembersOptions{ListOptions: github.ListOptions{PerPage: 1}}
+	_, err := g.callAPI(ctx, "list_members", func(ctx context.Context) (*github.Response, error) {
+		_, resp, err := g.ghClient.Organizations.ListMembers(ctx, g.Org, opts)
+		return resp, err
+	})
+	if err != nil {
+		return g.wrapError(err, fmt.Sprintf("GitHub token is unable to read the members of %s", g.Org))
+	}
+	return nil
+}
+
+// OrgAccessible checks with a single API call that the org exists and the token can read it, as a cheap
+// check before fetching members and teams. When it can't, the error's cause is ErrOrgNotFound or
+// ErrOrgForbidden, or whatever else stopped the call.
+func (g *GH) OrgAccessible() (bool, error) {
+	return g.OrgAccessibleContext(context.Background())
+}
+
+// OrgAccessibleContext is like OrgAccessible but uses the provided context for the API call
+func (g *GH) OrgAccessibleContext(ctx context.Context) (bool, error) {
+	_, err := g.callAPI(ctx, "get_org", func(ctx context.Context) (*github.Response, error) {
+		_, resp, err := g.ghClient.Organizations.Get(ctx, g.Org)
+		return resp, err
+	})
+	switch StatusCode(err) {
+	case 0:
+		if err != nil {
+			return false, g.wrapError(err, fmt.Sprintf("unable to look up organization %s", g.Org))
+		}
+		return true, nil
+	case http.StatusNotFound:
+		return false, errors.Wrap(ErrOrgNotFound, g.Org)
+	case http.StatusForbidden:
+		return false, errors.Wrap(ErrOrgForbidden, g.Org)
+	default:
+		return false, g.wrapError(err, fmt.Sprintf("unable to look up organization %s", g.Org))
+	}
+}
+
+// missingScopes returns the required scopes not granted by the comma separated scopes header
+func missingScopes(header string, required []string) []string {
+	granted := make(map[string]struct{})
+	for _, s := range strings.Split(header, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		granted[s] = struct{}{}
+		for _, implied := range impliedScopes[s] {
+			granted[implied] = struct{}{}
+		}
+	}
+
+	missing := []string{}
+	seen := make(map[string]struct{})
+	for _, s := range required {
+		if _, ok := seen[s]; ok {
+			continue
+		}
+		seen[s] = struct{}{}
+		if _, ok := granted[s]; !ok {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+// orgDetails holds the fields of an organization that go-github's Organization doesn't have yet
+type orgDetails struct {
+	DefaultRepoPermission string `json:"default_repository_permission"`
+}
+
+// getOrgMetadata fetches the details of the organization kept in OrgMetadata. It requests the same
+// endpoint as Organizations.Get, decoding the fields that type is missing.
+func (g *GH) getOrgMetadata(ctx context.Context) (OrgMetadata, error) {
+	req, err := g.ghClient.NewRequest("GET", "orgs/"+url.PathEscape(g.Org), nil)
+	if err != nil {
+		return OrgMetadata{}, errors.Wrap(err, "unable to create request")
+	}
+
+	var org orgDetails
+	if _, err := g.callAPI(ctx, "get_org", func(ctx context.Context) (*github.Response, error) {
+		org = orgDetails{}
+		return g.ghClient.Do(ctx, req, &org)
+	}); err != nil {
+		return OrgMetadata{}, g.wrapError(err, fmt.Sprintf("unable to look up organization %s", g.Org))
+	}
+	return OrgMetadata{DefaultRepoPermission: org.DefaultRepoPermission}, nil
+}
+
+// DefaultRepoPermission returns the base permission members have on the organization's repositories,
+// such as read or write. It is empty when the token can't see it, which takes an org owner's token.
+func (g *GH) DefaultRepoPermission() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.Metadata.DefaultRepoPermission
+}
@@ -0,0 +1,211 @@
+package directory
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+func TestMissingScopes(t *testing.T) {
+	cases := map[string]struct {
+		Header   string
+		Required []string
+		Expected []string
+	}{
+		"TestGranted":      {Header: "read:org, user:email", Required: []string{"read:org", "user:email"}, Expected: []string{}},
+		"TestMissing":      {Header: "read:org", Required: []string{"read:org", "user:email"}, Expected: []string{"user:email"}},
+		"TestImplied":      {Header: "admin:org, user", Required: []string{"read:org", "user:email"}, Expected: []string{}},
+		"TestNotImplied":   {Header: "read:org", Required: []string{"admin:org"}, Expected: []string{"admin:org"}},
+		"TestEmpty":        {Header: "", Required: []string{"read:org"}, Expected: []string{"read:org"}},
+		"TestDuplicateReq": {Header: "", Required: []string{"read:org", "read:org"}, Expected: []string{"read:org"}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := missingScopes(c.Header, c.Required)
+			if len(got) != len(c.Expected) {
+				t.Fatalf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+			}
+			for i := range got {
+				if got[i] != c.Expected[i] {
+					t.Errorf("Name: %s, got: %v, expected: %v", name, got, c.Expected)
+				}
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	scopes := ""
+	report := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if report {
+			w.Header().Set(oauthScopesHeader, scopes)
+		}
+		w.Write([]byte(`{"login":"test1"}`))
+	}))
+	defer server.Close()
+
+	g := &GH{ghClient: github.NewClient(nil)}
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+
+	scopes = "read:org"
+	if err := g.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := g.Validate(ScopeUserEmail); err == nil {
+		t.Errorf("expected an error for a missing user:email scope")
+	}
+	scopes = "repo"
+	if err := g.Validate(); err == nil {
+		t.Errorf("expected read:org to always be required")
+	}
+	report = false
+	if err := g.Validate(ScopeAdminOrg); err != nil {
+		t.Errorf("got: %v, expected tokens without scopes to pass", err)
+	}
+}
+
+func TestValidateFineGrained(t *testing.T) {
+	allowed := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fine-grained tokens send an empty scopes header
+		w.Header().Set(oauthScopesHeader, "")
+		if r.URL.Path != "/orgs/test/members" {
+			http.NotFound(w, r)
+			return
+		}
+		if !allowed {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"Resource not accessible by personal access token"}`))
+			return
+		}
+		w.Write([]byte(`[{"login":"test1"}]`))
+	}))
+	defer server.Close()
+
+	defer restoreEnv("GITHUB_TOKEN")()
+	os.Setenv("GITHUB_TOKEN", fineGrainedPrefix+"test")
+
+	g := &GH{ghClient: github.NewClient(nil)}
+	g.Org = "test"
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+
+	if err := g.Validate(); err != nil {
+		t.Errorf("got: %v, expected a fine-grained token with org access to pass", err)
+	}
+	allowed = false
+	if err := g.Validate(); err == nil {
+		t.Errorf("expected an error for a fine-grained token without org access")
+	}
+}
+
+func TestOrgAccessible(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/orgs/test":
+			w.Write([]byte(`{"login":"test"}`))
+		case "/orgs/sso":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"Resource protected by organization SAML enforcement"}`))
+		case "/orgs/broken":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message":"Bad request"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cases := map[string]struct {
+		Org        string
+		Accessible bool
+		Cause      error
+	}{
+		"TestAccessible": {Org: "test", Accessible: true},
+		"TestNotFound":   {Org: "notthere", Cause: ErrOrgNotFound},
+		"TestForbidden":  {Org: "sso", Cause: ErrOrgForbidden},
+		"TestOtherError": {Org: "broken"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			g := &GH{ghClient: github.NewClient(nil)}
+			g.Org = c.Org
+			g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+
+			ok, err := g.OrgAccessible()
+			if ok != c.Accessible || (err == nil) != c.Accessible {
+				t.Fatalf("Name: %s, got: %v %v, expected accessible: %v", name, ok, err, c.Accessible)
+			}
+			if c.Cause != nil && errors.Cause(err) != c.Cause {
+				t.Errorf("Name: %s, got: %v, expected cause: %v", name, err, c.Cause)
+			}
+		})
+	}
+}
+
+func TestDefaultRepoPermission(t *testing.T) {
+	server := testGitHubServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	newState := func(serverURL string) *GH {
+		g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+		g.Org = "test"
+		g.ghClient.BaseURL, _ = url.Parse(serverURL + "/")
+		g.UsersService = g.ghClient.Users
+		WithCacheDir(dir)(&g.opts)
+		return g
+	}
+
+	g := newState(server.URL)
+	if _, err := g.getMembersAndTeams(context.Background(), true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := g.DefaultRepoPermission(); got != "read" {
+		t.Errorf("got: %q, expected: %q", got, "read")
+	}
+
+	cached := newState(server.URL)
+	if _, err := cached.getMembersAndTeams(context.Background(), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := cached.DefaultRepoPermission(); !cached.FromCache() || got != "read" {
+		t.Errorf("got: %q from cache: %v, expected %q from the cache", got, cached.FromCache(), "read")
+	}
+
+	// An org the token can't look up still loads, just without the permission
+	hidden := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/orgs/test" {
+			http.NotFound(w, r)
+			return
+		}
+		server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer hidden.Close()
+	logger := &testLogger{}
+	g = newState(hidden.URL)
+	WithLogger(logger)(&g.opts)
+	if _, err := g.getMembersAndTeams(context.Background(), true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := g.DefaultRepoPermission(); got != "" || len(g.GetMembers()) != 2 {
+		t.Errorf("got: %q with %d members, expected no permission and both members", got, len(g.GetMembers()))
+	}
+	if len(logger.lines) == 0 {
+		t.Errorf("expected the failed lookup to be logged")
+	}
+}
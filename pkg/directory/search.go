@@ -0,0 +1,76 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/go-github/github"
+)
+
+// SearchMembers asks GitHub's user search for members matching query instead of searching the loaded
+// directory like GetMatches, for orgs too large to cache. The query is scoped with org: and may use
+// GitHub's other search qualifiers. Results that aren't loaded members are checked against the org's
+// membership, since the search can return users outside the org. Members only have their ID, login
+// and avatar filled in and are sorted by login. Queries shorter than WithMinQueryLength match nothing.
+func (g *GH) SearchMembers(ctx context.Context, query string) ([]Member, error) {
+	members := []Member{}
+	query = strings.TrimSpace(query)
+	if query == "" || utf8.RuneCountInString(query) < g.opts.minQueryLength {
+		return members, nil
+	}
+
+	nextPage := 1
+	for nextPage > 0 {
+		var result *github.UsersSearchResult
+		resp, err := g.listPage(ctx, "search_users", func(ctx context.Context) (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			result, resp, err = g.ghClient.Search.Users(ctx, query+" org:"+g.Org, &github.SearchOptions{
+				ListOptions: github.ListOptions{Page: nextPage, PerPage: g.opts.perPage},
+			})
+			return resp, err
+		})
+		if err != nil {
+			return nil, g.wrapError(err, "unable to search members on GitHub")
+		}
+
+		for _, u := range result.Users {
+			ok, err := g.searchedMember(ctx, u.GetLogin())
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				members = append(members, Member{ID: u.GetID(), Login: u.GetLogin(), AvatarURL: u.GetAvatarURL()})
+			}
+		}
+		nextPage = resp.NextPage
+	}
+
+	ByMembers(sortMemberLogins).Sort(members)
+	return members, nil
+}
+
+// searchedMember reports whether a user found by the search belongs to the org, asking GitHub when
+// they aren't a loaded member
+func (g *GH) searchedMember(ctx context.Context, login string) (bool, error) {
+	g.mu.RLock()
+	_, ok := g.findMember(login)
+	g.mu.RUnlock()
+	if ok {
+		return true, nil
+	}
+
+	var member bool
+	_, err := g.callAPI(ctx, "is_member", func(ctx context.Context) (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		member, resp, err = g.ghClient.Organizations.IsMember(ctx, g.Org, login)
+		return resp, err
+	})
+	if err != nil {
+		return false, g.wrapError(err, fmt.Sprintf("unable to check membership of %s", login))
+	}
+	return member, nil
+}
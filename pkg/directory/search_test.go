@@ -0,0 +1,61 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestSearchMembers(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/search/users":
+			queries = append(queries, r.URL.Query().Get("q"))
+			fmt.Fprint(w, `{"total_count":3,"items":[{"login":"test2","id":2},{"login":"test1","id":1},{"login":"outsider","id":3}]}`)
+		case "/orgs/test/members/test2":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+	g.Org = "test"
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+	g.Members = []Member{{Login: "test1", Name: "Test 1"}}
+	WithMinQueryLength(2)(&g.opts)
+
+	cases := map[string]struct {
+		query    string
+		expected []string
+		searched string
+	}{
+		"TestSearch":     {query: " test ", expected: []string{"test1", "test2"}, searched: "test org:test"},
+		"TestQualifiers": {query: "test location:Berlin", expected: []string{"test1", "test2"}, searched: "test location:Berlin org:test"},
+		"TestTooShort":   {query: "t", expected: []string{}},
+		"TestEmptyQuery": {query: "  ", expected: []string{}},
+	}
+
+	for name, c := range cases {
+		queries = nil
+		members, err := g.SearchMembers(context.Background(), c.query)
+		if err != nil {
+			t.Errorf("Name: %s, unexpected error: %v", name, err)
+			continue
+		}
+		checkLogins(t, name, members, c.expected)
+		if c.searched == "" && len(queries) != 0 {
+			t.Errorf("Name: %s, got: %v, expected no search", name, queries)
+		}
+		if c.searched != "" && (len(queries) != 1 || queries[0] != c.searched) {
+			t.Errorf("Name: %s, got: %v, expected: %s", name, queries, c.searched)
+		}
+	}
+}
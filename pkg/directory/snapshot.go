@@ -0,0 +1,102 @@
+package directory
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	snapshotName = "snapshot"
+	// snapshotVersion is bumped when a snapshot changes in a way older clients can't read
+	snapshotVersion = 1
+)
+
+// snapshot holds everything the separate cache files do in a single document. It is also the layout
+// of the cache with WithSingleFileCache. The team index isn't stored since it is rebuilt on load.
+type snapshot struct {
+	// Version is zero for snapshots written before it was recorded, which are read the same as version 1
+	Version           int         `json:"version,omitempty"`
+	Org               string      `json:"org"`
+	SavedAt           time.Time   `json:"savedAt,omitempty"`
+	Members           []Member    `json:"members"`
+	Teams             []Team      `json:"teams"`
+	ActiveMemberTeams []string    `json:"activeMemberTeams"`
+	Metadata          OrgMetadata `json:"metadata"`
+}
+
+// newSnapshot builds a snapshot of info, leaving out secret teams with WithoutSecretTeams
+func (g *GH) newSnapshot(info Info) snapshot {
+	return snapshot{
+		Version:           snapshotVersion,
+		Org:               info.Org,
+		SavedAt:           time.Now(),
+		Members:           info.Members,
+		Teams:             g.cacheableTeams(info.Teams),
+		ActiveMemberTeams: g.cacheableTeamNames(info.ActiveMemberTeams, info.Teams),
+		Metadata:          info.Metadata,
+	}
+}
+
+// currentInfo copies out the members and teams currently loaded
+func (g *GH) currentInfo() Info {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return Info{Org: g.Org, Members: g.Members, Teams: g.Info.Teams, ActiveMemberTeams: g.ActiveMemberTeams, Metadata: g.Metadata}
+}
+
+// SaveSnapshot writes the current members and teams to w. It is serialized like the cache, using the
+// configured cache format and encryption key and leaving out secret teams with WithoutSecretTeams, so
+// it can be baked into an image and loaded later with LoadSnapshot without access to GitHub.
+func (g *GH) SaveSnapshot(w io.Writer) error {
+	buf, err := g.marshalCache(snapshotName, g.newSnapshot(g.currentInfo()))
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buf); err != nil {
+		return errors.Wrap(err, "unable to write snapshot")
+	}
+	return nil
+}
+
+// LoadSnapshot replaces the members and teams with ones written by SaveSnapshot. The snapshot must be
+// for the same organization and, when a cache key is set, encrypted with it. Nothing is fetched from
+// GitHub and the on-disk cache is left alone.
+func (g *GH) LoadSnapshot(r io.Reader) error {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "unable to read snapshot")
+	}
+
+	var s snapshot
+	if err := g.unmarshalCache(snapshotName, buf, &s); err != nil {
+		if errors.Cause(err) == errCacheMiss {
+			return errors.New("snapshot is not encrypted but a cache key was provided")
+		}
+		return err
+	}
+	if s.Version > snapshotVersion {
+		return fmt.Errorf("snapshot is version %d, this version of psst reads up to version %d", s.Version, snapshotVersion)
+	}
+
+	g.mu.RLock()
+	org := g.Org
+	g.mu.RUnlock()
+	if org != "" && !strings.EqualFold(org, s.Org) {
+		return fmt.Errorf("snapshot is for organization '%s', not '%s'", s.Org, org)
+	}
+
+	g.setInfo(Info{Members: s.Members, Teams: s.Teams, ActiveMemberTeams: s.ActiveMemberTeams, Metadata: s.Metadata})
+	g.mu.Lock()
+	if g.Org == "" {
+		g.Org = s.Org
+	}
+	g.lastRefresh = RefreshResult{MembersFetched: len(s.Members), TeamsFetched: len(s.Teams), FromCache: true}
+	g.mu.Unlock()
+	return nil
+}
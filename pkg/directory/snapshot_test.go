@@ -0,0 +1,79 @@
+package directory
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	members := []Member{Member{Login: "test1", Name: "Test 1"}, Member{Login: "test2"}}
+	teams := []Team{Team{Name: "team1", Members: []string{"test1"}}}
+
+	cases := map[string]struct {
+		Options []Option
+	}{
+		"TestJSON":      {},
+		"TestGob":       {Options: []Option{WithCacheFormat(CacheFormatGob)}},
+		"TestEncrypted": {Options: []Option{WithCacheEncryption(key)}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			src := newSnapshotState(t, "dsc", c.Options...)
+			src.setInfo(Info{Members: members, Teams: teams, ActiveMemberTeams: []string{"team1"}})
+
+			var buf bytes.Buffer
+			if err := src.SaveSnapshot(&buf); err != nil {
+				t.Fatalf("Name: %s, unable to save snapshot: %v", name, err)
+			}
+
+			dst := newSnapshotState(t, "", c.Options...)
+			if err := dst.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+				t.Fatalf("Name: %s, unable to load snapshot: %v", name, err)
+			}
+			if dst.Org != "dsc" {
+				t.Errorf("Name: %s, got org: %s, expected dsc", name, dst.Org)
+			}
+			if !checkMembers(dst.GetMembers(), members) {
+				t.Errorf("Name: %s, got: %+v, expected: %+v", name, dst.GetMembers(), members)
+			}
+			if got := dst.GetTeamMembers("team1"); len(got) != 1 || got[0] != "test1" {
+				t.Errorf("Name: %s, got: %v, expected team1 to have test1", name, got)
+			}
+			if got := dst.GetMemberTeams("test1"); len(got) != 1 {
+				t.Errorf("Name: %s, got: %v, expected the team index to be rebuilt", name, got)
+			}
+			if !dst.LastRefresh().FromCache {
+				t.Errorf("Name: %s, expected the snapshot to be reported as cached", name)
+			}
+		})
+	}
+}
+
+func TestSnapshotMismatch(t *testing.T) {
+	src := newSnapshotState(t, "dsc")
+	var buf bytes.Buffer
+	if err := src.SaveSnapshot(&buf); err != nil {
+		t.Fatalf("unable to save snapshot: %v", err)
+	}
+
+	if err := newSnapshotState(t, "other").LoadSnapshot(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Errorf("expected a snapshot for another organization to fail")
+	}
+	encrypted := newSnapshotState(t, "dsc", WithCacheEncryption(bytes.Repeat([]byte{1}, 32)))
+	if err := encrypted.LoadSnapshot(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Errorf("expected a plaintext snapshot to fail when a key is set")
+	}
+}
+
+func newSnapshotState(t *testing.T, org string, opts ...Option) *GH {
+	g := &GH{Info: Info{Org: org}}
+	for _, opt := range opts {
+		opt(&g.opts)
+	}
+	if err := g.setupCacheEncryption(); err != nil {
+		t.Fatalf("unable to set up encryption: %v", err)
+	}
+	return g
+}
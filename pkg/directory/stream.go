@@ -0,0 +1,117 @@
+package directory
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// StreamMembers fetches every member from GitHub and calls fn with each one as it is looked up, without
+// keeping them or updating the directory and cache. Members arrive in no particular order from a
+// single goroutine. After fn returns an error it isn't called again, the lookups still running are
+// cancelled and the error is returned. Cancelling ctx stops the fetch the same way.
+func (g *GH) StreamMembers(ctx context.Context, fn func(Member) error) error {
+	_, _, err := g.streamMembers(ctx, fn)
+	return err
+}
+
+// ForEachCachedMember calls fn with each member in the cache, in the order they were saved, stopping
+// at the first error. Cache files written unencrypted with CacheFormatJSONLines are read one member at a
+// time, others and caches kept with WithCacheStore are loaded whole first.
+func (g *GH) ForEachCachedMember(fn func(Member) error) error {
+	return g.ForEachCachedMemberContext(context.Background(), fn)
+}
+
+// ForEachCachedMemberContext is like ForEachCachedMember but stops with ctx's error once it is cancelled
+func (g *GH) ForEachCachedMemberContext(ctx context.Context, fn func(Member) error) error {
+	each := func(m Member) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(m)
+	}
+
+	if g.opts.singleFileCache {
+		var s snapshot
+		if err := g.getCached(g.cacheFile(directoryCacheFile), &s); err != nil {
+			return err
+		}
+		return forEachMember(s.Members, each)
+	}
+
+	filename := g.cacheFile(membersCacheFile)
+	if g.opts.cacheStore != nil {
+		return g.forEachCachedMemberLoaded(filename, each)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to read cached file %s", filename))
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header, err := r.Peek(1)
+	if err != nil || header[0] != cacheHeaderJSONLines || g.cacheCipher != nil {
+		return g.forEachCachedMemberLoaded(filename, each)
+	}
+
+	// Check the whole file before handing anything out so a damaged cache isn't partly used
+	if err := verifyChecksum(filename, r); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to read cached file %s", filename))
+	}
+	r.Reset(f)
+	if _, err := r.ReadByte(); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to read cached file %s", filename))
+	}
+
+	var members []Member
+	err = readJSONLines(r, &members, func(v interface{}) error {
+		return each(v.(Member))
+	})
+	return errors.Wrap(err, fmt.Sprintf("unable to read cached members from %s", filename))
+}
+
+// forEachCachedMemberLoaded loads the whole members cache before calling each with them
+func (g *GH) forEachCachedMemberLoaded(filename string, each func(Member) error) error {
+	var members []Member
+	if err := g.getCached(filename, &members); err != nil {
+		return err
+	}
+	return forEachMember(members, each)
+}
+
+// forEachMember calls each with the members in order, stopping at the first error
+func forEachMember(members []Member, each func(Member) error) error {
+	for _, m := range members {
+		if err := each(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyChecksum hashes the cache file from r and compares it to the saved checksum
+func verifyChecksum(filename string, r io.Reader) error {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to read cached file %s", filename))
+	}
+
+	sum, err := ioutil.ReadFile(checksumFile(filename))
+	if err != nil || strings.TrimSpace(string(sum)) != hex.EncodeToString(hash.Sum(nil)) {
+		return errCacheMiss
+	}
+	return nil
+}
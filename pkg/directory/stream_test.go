@@ -0,0 +1,148 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+)
+
+func TestForEachCachedMember(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psst-cache")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(orig string) { cacheDir = orig }(cacheDir)
+	cacheDir = dir
+
+	members := []Member{Member{Login: "test1", Name: "Test 1"}, Member{Login: "test2"}, Member{Login: "test3"}}
+
+	cases := map[string]struct {
+		Format CacheFormat
+	}{
+		"TestJSON":      {Format: CacheFormatJSON},
+		"TestGob":       {Format: CacheFormatGob},
+		"TestJSONLines": {Format: CacheFormatJSONLines},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			g := &GH{Info: Info{Org: name}, opts: options{cacheFormat: c.Format}}
+			if err := os.MkdirAll(g.orgCacheDir(), 0700); err != nil {
+				t.Fatalf("Name: %s, unable to create cache dir: %v", name, err)
+			}
+			if err := g.saveCache(g.cacheFile(membersCacheFile), members); err != nil {
+				t.Fatalf("Name: %s, unable to save cache: %v", name, err)
+			}
+
+			got := []Member{}
+			if err := g.ForEachCachedMember(func(m Member) error {
+				got = append(got, m)
+				return nil
+			}); err != nil {
+				t.Fatalf("Name: %s, unexpected error: %v", name, err)
+			}
+			checkLogins(t, name, got, []string{"test1", "test2", "test3"})
+
+			stop := errors.New("stop")
+			calls := 0
+			err := g.ForEachCachedMember(func(m Member) error {
+				calls++
+				return stop
+			})
+			if err == nil || calls != 1 {
+				t.Errorf("Name: %s, got: %v after %d calls, expected to stop after the first", name, err, calls)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			calls = 0
+			err = g.ForEachCachedMemberContext(ctx, func(m Member) error {
+				calls++
+				cancel()
+				return nil
+			})
+			if errors.Cause(err) != context.Canceled || calls != 1 {
+				t.Errorf("Name: %s, got: %v after %d calls, expected to stop once cancelled", name, err, calls)
+			}
+
+			if err := ioutil.WriteFile(g.cacheFile(membersCacheFile), []byte{cacheHeaderJSONLines, '{', '}'}, 0700); err != nil {
+				t.Fatalf("Name: %s, unable to damage cache: %v", name, err)
+			}
+			if err := g.ForEachCachedMember(func(Member) error { return nil }); err == nil {
+				t.Errorf("Name: %s, expected a damaged cache to fail its checksum", name)
+			}
+		})
+	}
+}
+
+func TestStreamMembersStopsOnError(t *testing.T) {
+	server := testGitHubServer()
+	defer server.Close()
+
+	g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+	g.Org = "test"
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+	g.UsersService = g.ghClient.Users
+	WithWorkers(1)(&g.opts)
+
+	stop := errors.New("stop")
+	calls := 0
+	err := g.StreamMembers(context.Background(), func(Member) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("got: %v after %d calls, expected the error from fn after one call", err, calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.StreamMembers(ctx, func(Member) error { return nil }); err == nil {
+		t.Errorf("expected a cancelled context to stop the fetch")
+	}
+}
+
+func TestStreamMembersSkipsRemoved(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/user":
+			fmt.Fprint(w, `{"login":"test1"}`)
+		case "/orgs/test/members":
+			fmt.Fprint(w, `[{"login":"test1"},{"login":"removed"}]`)
+		case "/users/test1":
+			fmt.Fprint(w, `{"login":"test1"}`)
+		case "/user/teams":
+			fmt.Fprint(w, `[]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	logger := &testLogger{}
+	g := &GH{ghClient: github.NewClient(nil), opts: defaultOptions()}
+	g.Org = "test"
+	g.ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+	g.UsersService = g.ghClient.Users
+	WithLogger(logger)(&g.opts)
+
+	got := []Member{}
+	if err := g.StreamMembers(context.Background(), func(m Member) error {
+		got = append(got, m)
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkLogins(t, "TestStreamMembersSkipsRemoved", got, []string{"test1"})
+	if len(logger.lines) != 1 {
+		t.Errorf("got: %v, expected a warning about the removed member", logger.lines)
+	}
+}
@@ -0,0 +1,87 @@
+package directory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	// Below this share of the rate limit remaining only one request is made at a time
+	throttleMinRate = 0.1
+	// At or above this share of the rate limit remaining every worker may make requests
+	throttleFullRate = 0.5
+)
+
+// throttle limits how many workers call GitHub at once based on the remaining rate limit reported in
+// responses, so a large fetch slows down instead of exhausting the token. A nil throttle doesn't limit.
+type throttle struct {
+	mu sync.Mutex
+	// released is closed and replaced whenever a request ends, waking everyone waiting in acquire
+	released chan struct{}
+	max      int
+	limit    int
+	active   int
+}
+
+func newThrottle(max int) *throttle {
+	if max < 1 {
+		max = 1
+	}
+	return &throttle{max: max, limit: max, released: make(chan struct{})}
+}
+
+// acquire blocks until another request may be made, or returns ctx's error once it is done
+func (t *throttle) acquire(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	for t.active >= t.limit {
+		released := t.released
+		t.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		}
+		t.mu.Lock()
+	}
+	t.active++
+	t.mu.Unlock()
+	return nil
+}
+
+// release ends a request started with acquire and adjusts the limit to the rate in the response, which
+// may be nil when the request failed
+func (t *throttle) release(resp *github.Response) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.active--
+	if resp != nil && resp.Rate.Limit > 0 {
+		t.limit = t.limitFor(float64(resp.Rate.Remaining) / float64(resp.Rate.Limit))
+	}
+	close(t.released)
+	t.released = make(chan struct{})
+}
+
+// limitFor scales the concurrency between one and max as the remaining share of the rate limit drops
+// from throttleFullRate to throttleMinRate
+func (t *throttle) limitFor(rate float64) int {
+	switch {
+	case rate < throttleMinRate:
+		return 1
+	case rate >= throttleFullRate:
+		return t.max
+	}
+	limit := int(float64(t.max)*(rate-throttleMinRate)/(throttleFullRate-throttleMinRate) + 0.5)
+	if limit < 1 {
+		return 1
+	}
+	return limit
+}
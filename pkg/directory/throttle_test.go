@@ -0,0 +1,106 @@
+package directory
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func rateResponse(remaining, limit int) *github.Response {
+	return &github.Response{Rate: github.Rate{Remaining: remaining, Limit: limit}}
+}
+
+func TestThrottleLimit(t *testing.T) {
+	cases := map[string]struct {
+		Resp     *github.Response
+		Expected int
+	}{
+		"TestPlenty":     {Resp: rateResponse(4000, 5000), Expected: 10},
+		"TestHalf":       {Resp: rateResponse(2500, 5000), Expected: 10},
+		"TestReduced":    {Resp: rateResponse(1500, 5000), Expected: 5},
+		"TestLow":        {Resp: rateResponse(100, 5000), Expected: 1},
+		"TestFailedCall": {Resp: nil, Expected: 10},
+		"TestNoLimit":    {Resp: rateResponse(0, 0), Expected: 10},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			th := newThrottle(10)
+			th.acquire(context.Background())
+			th.release(c.Resp)
+			if th.limit != c.Expected {
+				t.Errorf("Name: %s, got: %d, expected: %d", name, th.limit, c.Expected)
+			}
+		})
+	}
+}
+
+func TestThrottleRecovers(t *testing.T) {
+	th := newThrottle(4)
+	th.acquire(context.Background())
+	th.release(rateResponse(10, 5000))
+	if th.limit != 1 {
+		t.Fatalf("got: %d, expected the limit to drop to 1", th.limit)
+	}
+
+	th.acquire(context.Background())
+	acquired := make(chan struct{})
+	go func() {
+		th.acquire(context.Background())
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("expected a second request to wait while the limit is 1")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	th.release(rateResponse(5000, 5000))
+	<-acquired
+	if th.limit != 4 {
+		t.Errorf("got: %d, expected the limit to recover to 4", th.limit)
+	}
+}
+
+func TestThrottleCancel(t *testing.T) {
+	th := newThrottle(1)
+	th.acquire(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	acquired := make(chan error)
+	go func() {
+		acquired <- th.acquire(ctx)
+	}()
+	cancel()
+	select {
+	case err := <-acquired:
+		if err != context.Canceled {
+			t.Errorf("got: %v, expected the cancellation to be returned", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected a cancelled request to stop waiting")
+	}
+
+	// The cancelled request never took a slot
+	th.release(nil)
+	if th.active != 0 {
+		t.Errorf("got: %d, expected no active requests", th.active)
+	}
+}
+
+func TestNilThrottle(t *testing.T) {
+	var th *throttle
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			th.acquire(context.Background())
+			th.release(nil)
+		}()
+	}
+	wg.Wait()
+}